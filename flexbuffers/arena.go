@@ -0,0 +1,130 @@
+package flexbuffers
+
+import "fmt"
+
+//Elements are allocated in blocks, so that the pointers bound to offsets stay valid while a structure grows
+const arenaBlockSize = 64
+
+//An arena recycles the elements and structures of a Builder. Everything handed out since the last reset is reused
+//after the next one, so a builder that has been warmed up with similar values does not allocate
+type arena struct {
+	blocks   [][]element
+	block    int //index of the block elements are currently taken from
+	used     int //number of elements taken from that block
+	vectors  []*vector
+	nVectors int
+	typed    []*typedVector
+	nTyped   int
+	fixed    []*fixedTypedVector
+	nFixed   int
+	maps     []*flexMap
+	nMaps    int
+	blobs    []*blob
+	nBlobs   int
+	strings  []*flexString
+	nStrings int
+	keys     []*key
+	nKeys    int
+}
+
+func (a *arena) reset() {
+	a.block, a.used = 0, 0
+	a.nVectors, a.nTyped, a.nFixed, a.nMaps, a.nBlobs, a.nStrings, a.nKeys = 0, 0, 0, 0, 0, 0, 0
+}
+
+func (a *arena) element() *element {
+	if a.block == len(a.blocks) {
+		a.blocks = append(a.blocks, make([]element, arenaBlockSize))
+	}
+	e := &a.blocks[a.block][a.used]
+	a.used++
+	if a.used == arenaBlockSize {
+		a.block++
+		a.used = 0
+	}
+	return e
+}
+
+func (a *arena) newVector() *vector {
+	if a.nVectors == len(a.vectors) {
+		a.vectors = append(a.vectors, new(vector))
+	}
+	v := a.vectors[a.nVectors]
+	a.nVectors++
+	v.reset(a, VECTOR)
+	return v
+}
+
+func (a *arena) newTypedVector(vType VarType) *typedVector {
+	if !isTypedVector(vType) {
+		panic(fmt.Sprintf("Can not create a typed vector of type %s", vType.toString()))
+	}
+	if a.nTyped == len(a.typed) {
+		a.typed = append(a.typed, new(typedVector))
+	}
+	v := a.typed[a.nTyped]
+	a.nTyped++
+	v.reset(a, vType)
+	return v
+}
+
+func (a *arena) newFixedTypedVector(vType VarType) *fixedTypedVector {
+	if !isFixedTypedVector(vType) {
+		panic(fmt.Sprintf("Can not create a fixed typed vector of type %s", vType.toString()))
+	}
+	if a.nFixed == len(a.fixed) {
+		a.fixed = append(a.fixed, new(fixedTypedVector))
+	}
+	v := a.fixed[a.nFixed]
+	a.nFixed++
+	v.reset(a, vType)
+	return v
+}
+
+func (a *arena) newFlexMap() *flexMap {
+	if a.nMaps == len(a.maps) {
+		a.maps = append(a.maps, newFlexMap())
+	}
+	m := a.maps[a.nMaps]
+	a.nMaps++
+	m.reset(a, MAP)
+	m.keys.reset(a, VECTOR_KEY)
+	m.shared, m.schema = nil, ""
+	return m
+}
+
+func (a *arena) newBlob(bytes []byte) *blob {
+	if a.nBlobs == len(a.blobs) {
+		a.blobs = append(a.blobs, new(blob))
+	}
+	v := a.blobs[a.nBlobs]
+	a.nBlobs++
+	v.reset(a, BLOB)
+	v.bSize = b8
+	v.data = append(v.data[:0], bytes...)
+	return v
+}
+
+func (a *arena) newFlexString(str string) *flexString {
+	if a.nStrings == len(a.strings) {
+		a.strings = append(a.strings, new(flexString))
+	}
+	v := a.strings[a.nStrings]
+	a.nStrings++
+	v.reset(a, STRING)
+	v.bSize = b8
+	v.data = append(v.data[:0], str...)
+	return v
+}
+
+func (a *arena) newKey(str string) *key {
+	if a.nKeys == len(a.keys) {
+		a.keys = append(a.keys, new(key))
+	}
+	k := a.keys[a.nKeys]
+	a.nKeys++
+	k.reset(a, KEY)
+	k.bSize = b8
+	k.data = append(k.data[:0], str...)
+	return k
+}
@@ -4,15 +4,51 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unsafe"
 )
 
+//Decides how many bytes are used to store a float
+type FloatPolicy uint8
+
+const (
+	FloatLossless FloatPolicy = iota //float32 when the value can be represented without loss, float64 otherwise
+	FloatAlways32                    //always float32, values are rounded to the nearest float32
+	FloatAlways64                    //always float64
+)
+
+//Options changing the output of a Builder. The zero value matches NewBuilder.
+//Scalars are stored with the width of the vector, map or root holding them, so ForceMinBitWidth widens them as well.
+//The size prefixes of strings, blobs and keys keep the narrowest width, as in the reference implementation
+type BuilderOptions struct {
+	ShareStrings     bool        //identical strings are serialized once and referenced by every occurrence (share_strings)
+	ShareKeys        bool        //identical keys are serialized once and referenced by every occurrence (share_keys)
+	ShareKeyVectors  bool        //maps with identical keys point to the key vector of the first one (share_key_vectors)
+	ShareStructures  bool        //identical vectors, maps and blobs are serialized once, implies ShareStrings and ShareKeys
+	ForceMinBitWidth ByteSize    //minimum width of vectors, maps and the root, one of W8, W16, W32, W64 (force_min_bit_width)
+	Floats           FloatPolicy //width of floats, FloatLossless by default
+}
+
 type Builder struct {
+	options        BuilderOptions
+	stringPool     map[string]*flexString
+	keyPool        map[string]*key
 	finished       bool
 	inProgress     []iStructure
 	inProgressInit [4]iStructure
 	headIndex      int
+	pendingKey     *key                    //key for the next value added without a key, set while a Marshaler builds a map value
+	keyVectorPool  map[hash64][]*keyVector //key vectors of finished maps by the hash of their keys, see ShareKeyVectors
+	schemaPool     map[string]*keyVector   //key vectors of finished maps by schema, see StartMapWithSharedKeys
+	structurePool  map[hash64][]iStructure //finished structures by the hash of their content, see ShareStructures
+	dedupCounts    map[iStructure]int      //number of times a pooled structure has been used instead of a new one
+	dedupSaved     int                     //bytes saved by dedupCounts, counted when the buffer is serialized
+	autoDepth      int                     //number of pointers, maps and slices AutoBuild is in
+	autoSeen       map[visit]struct{}      //pointers, maps and slices AutoBuild is in, once it is nested deep enough
+	arena          arena
+	buff           []byte //scratch buffer for Bytes and MarshalAppend
 	root
 }
 
@@ -20,13 +56,79 @@ type Builder struct {
 func NewBuilder() *Builder {
 	b := new(Builder)
 	//b.root = Root{}
+	b.root.arena = &b.arena
 	b.inProgress = b.inProgressInit[:0]
 	b.inProgress = append(b.inProgress, &b.root)
 	return b
 }
 
+func NewBuilderWithOptions(opts BuilderOptions) *Builder {
+	b := NewBuilder()
+	if opts.ShareStructures {
+		//structures are compared by the identity of their children, so identical strings and keys have to be shared
+		opts.ShareStrings, opts.ShareKeys = true, true
+	}
+	b.options = opts
+	b.root.setMinBitWidth(opts.ForceMinBitWidth)
+	if opts.ShareStrings {
+		b.stringPool = map[string]*flexString{}
+	}
+	if opts.ShareKeys {
+		b.keyPool = map[string]*key{}
+	}
+	return b
+}
+
+//Reset discards everything added to the builder, so that it can be used to build a new buffer. The options are kept,
+//as well as the memory allocated so far: building values of a similar shape again does not allocate
+func (b *Builder) Reset() {
+	b.arena.reset()
+	b.root.reset(&b.arena, NULL)
+	b.root.setMinBitWidth(b.options.ForceMinBitWidth)
+	b.finished = false
+	b.inProgress = append(b.inProgress[:0], &b.root)
+	b.headIndex = 0
+	b.pendingKey = nil
+	for s := range b.stringPool {
+		delete(b.stringPool, s)
+	}
+	for k := range b.keyPool {
+		delete(b.keyPool, k)
+	}
+	for h := range b.keyVectorPool {
+		delete(b.keyVectorPool, h)
+	}
+	for id := range b.schemaPool {
+		delete(b.schemaPool, id)
+	}
+	for h := range b.structurePool {
+		delete(b.structurePool, h)
+	}
+	for o := range b.dedupCounts {
+		delete(b.dedupCounts, o)
+	}
+	b.dedupSaved = 0
+	b.autoDepth = 0
+	for p := range b.autoSeen {
+		delete(b.autoSeen, p)
+	}
+	b.buff = b.buff[:0]
+}
+
 func (b *Builder) SerializeBuffer(buff *[]byte) (int, error) {
-	return serialize(&b.root, buff, false)
+	n, err := serialize(&b.root, buff)
+	if err == nil {
+		b.dedupSaved = b.savedBytes()
+	}
+	return n, err
+}
+
+//Bytes serializes the finished buffer into memory owned by the builder. The result is only valid until the next call
+//to Bytes or Reset
+func (b *Builder) Bytes() ([]byte, error) {
+	b.buff = b.buff[:0]
+	_, err := b.SerializeBuffer(&b.buff)
+	return b.buff, err
 }
 
 func (b *Builder) Finish() error {
@@ -48,6 +150,13 @@ func (b *Builder) End() {
 	if b.headIndex < 1 {
 		panic("No structure to end")
 	}
+	head := b.getHead()
+	//a structure replaced by an identical one is not part of the buffer anymore, the one it is replaced with has been
+	//ended already
+	deduped := b.dedup(head, b.inProgress[b.headIndex-1])
+	if m, ok := head.(*flexMap); ok && !deduped {
+		b.endMap(m)
+	}
 	b.inProgress = b.inProgress[:b.headIndex]
 	b.headIndex--
 	if b.headIndex == 0 {
@@ -61,7 +170,17 @@ func (b *Builder) getHead() iStructure {
 	return b.inProgress[b.headIndex]
 }
 
+func (b *Builder) takePendingKey() *key {
+	k := b.pendingKey
+	b.pendingKey = nil
+	return k
+}
+
 func (b *Builder) start(o iStructure) error {
+	if k := b.takePendingKey(); k != nil {
+		return b.startWithKey(k, o)
+	}
+	o.setMinBitWidth(b.options.ForceMinBitWidth)
 	_, err := addOffsetToObject(b.getHead(), o)
 	if err != nil {
 		return err
@@ -72,22 +191,90 @@ func (b *Builder) start(o iStructure) error {
 	return nil
 }
 
-func (b *Builder) startWithKey(k *key, o iStructure) error {
+func (b *Builder) mapHead(k *key) (*flexMap, error) {
 	head := b.getHead()
 	m, ok := head.(*flexMap)
 	if k == nil {
-		return fmt.Errorf("key must not be nil")
+		return nil, fmt.Errorf("key must not be nil")
 	}
 	if !ok {
-		return fmt.Errorf("type %T does not support key mapping", head)
+		return nil, fmt.Errorf("type %T does not support key mapping", head)
+	}
+	return m, nil
+}
+
+//returns an identical key that is already part of the buffer if keys are shared. Otherwise k is returned
+//and reported as owned by the caller
+func (b *Builder) shareKey(k *key) (*key, bool) {
+	if pooled := b.pooled(k); pooled != nil {
+		return pooled.(*key), false
+	}
+	return k, true
+}
+
+//points a finished map to the key vector of an earlier map with the same keys, if key vectors are shared
+func (b *Builder) endMap(m *flexMap) {
+	if m.schema == "" && !b.options.ShareKeyVectors {
+		return
+	}
+	var kv *keyVector
+	if m.schema != "" {
+		if pooled, ok := b.schemaPool[m.schema]; ok && m.sameKeys(pooled) {
+			kv = pooled
+		}
+	}
+	h := hash64(fnvOffset64)
+	if b.options.ShareKeyVectors {
+		for i := range m.keys.children {
+			h = h.bytes(m.keyAt(i).data)
+		}
+		for _, pooled := range b.keyVectorPool[h] {
+			if kv == nil && m.sameKeys(pooled) {
+				kv = pooled
+			}
+		}
 	}
-	if k.toString() == "" {
-		return fmt.Errorf("mapping with empty key not allowed")
+	if kv == nil {
+		//the first map with these keys or this schema
+		if m.schema != "" {
+			if b.schemaPool == nil {
+				b.schemaPool = map[string]*keyVector{}
+			}
+			if _, ok := b.schemaPool[m.schema]; !ok {
+				b.schemaPool[m.schema] = m.keys
+			}
+		}
+		if b.options.ShareKeyVectors {
+			if b.keyVectorPool == nil {
+				b.keyVectorPool = map[hash64][]*keyVector{}
+			}
+			b.keyVectorPool[h] = append(b.keyVectorPool[h], m.keys)
+		}
+		return
+	}
+	for _, i := range m.shareKeyVector(kv) {
+		//keys that are not serialized anymore can not be shared either, the ones of kv are used instead
+		k := m.keyAt(i)
+		if b.keyPool[string(k.data)] == k {
+			b.keyPool[string(k.data)] = kv.children[i].(*key)
+		}
+	}
+}
+
+func (b *Builder) startWithKey(k *key, o iStructure) error {
+	m, err := b.mapHead(k)
+	if err != nil {
+		return err
 	}
-	_, err := m.addOffsetWithKey(k, o)
+	o.setMinBitWidth(b.options.ForceMinBitWidth)
+	k, owned := b.shareKey(k)
+	_, err = m.addOffsetWithKey(k, owned, o)
 	if err != nil {
 		return err
 	}
+	if owned {
+		b.addToPool(k)
+	}
 	b.headIndex++
 	b.inProgress = append(b.inProgress, o)
 	return nil
@@ -101,6 +288,9 @@ func (b *Builder) startWithOptionalKey(k *key, o iStructure) error {
 }
 
 func (b *Builder) registerElement(elem element) error {
+	if k := b.takePendingKey(); k != nil {
+		return b.registerElementWithKey(k, elem)
+	}
 	head := b.getHead()
 	_, err := addElement(head, elem)
 	if err != nil {
@@ -113,18 +303,15 @@ func (b *Builder) registerElement(elem element) error {
 }
 
 func (b *Builder) registerElementWithKey(k *key, elem element) error {
-	head := b.getHead()
-	m, ok := head.(*flexMap)
-	if k == nil {
-		return fmt.Errorf("key must not be nil")
-	}
-	if !ok {
-		return fmt.Errorf("type %T does not support key mapping", head)
+	m, err := b.mapHead(k)
+	if err != nil {
+		return err
 	}
-	if k.toString() == "" {
-		return fmt.Errorf("mapping with empty key not allowed")
+	k, owned := b.shareKey(k)
+	_, err = m.addElementWithKey(k, owned, elem)
+	if err == nil && owned {
+		b.addToPool(k)
 	}
-	_, err := m.addElementWithKey(k, elem)
 	return err
 }
 
@@ -135,6 +322,88 @@ func (b *Builder) registerElementWithOptionalKey(k *key, elem element) error {
 	return b.registerElementWithKey(k, elem)
 }
 
+func (b *Builder) newFLOAT(f float64) element {
+	switch b.options.Floats {
+	case FloatAlways32:
+		e := newFLOAT(float64(float32(f)))
+		e.fieldSize = b32
+		return e
+	case FloatAlways64:
+		e := newFLOAT(f)
+		e.fieldSize = b64
+		return e
+	}
+	return newFLOAT(f)
+}
+
+//returns an identical string or key already added to the builder, if sharing is enabled for its type
+func (b *Builder) pooled(o iStructure) iStructure {
+	switch x := o.(type) {
+	case *key:
+		if pooled, ok := b.keyPool[string(x.data)]; ok {
+			return pooled
+		}
+	case *flexString:
+		if pooled, ok := b.stringPool[string(x.data)]; ok {
+			return pooled
+		}
+	}
+	return nil
+}
+
+func (b *Builder) addToPool(o iStructure) {
+	switch x := o.(type) {
+	case *key:
+		if b.options.ShareKeys {
+			b.keyPool[string(x.data)] = x
+		}
+	case *flexString:
+		if b.options.ShareStrings {
+			b.stringPool[string(x.data)] = x
+		}
+	}
+}
+
+//adds a string or key that is complete on creation, so that it can be shared with an identical one
+func (b *Builder) addShareable(k *key, o iStructure) error {
+	if pooled := b.pooled(o); pooled != nil {
+		return b.bindWithOptionalKey(k, pooled)
+	}
+	if err := b.startWithOptionalKey(k, o); err != nil {
+		return err
+	}
+	b.End()
+	b.addToPool(o)
+	return nil
+}
+
+//adds an offset to a structure owned by another structure
+func (b *Builder) bindWithOptionalKey(k *key, o iStructure) error {
+	if k == nil {
+		k = b.takePendingKey()
+	}
+	if k == nil {
+		head := b.getHead()
+		if _, err := head.insertOffset(o, head.elemsCount()); err != nil {
+			return err
+		}
+		if len(b.inProgress) == 1 {
+			b.Finish()
+		}
+		return nil
+	}
+	m, err := b.mapHead(k)
+	if err != nil {
+		return err
+	}
+	k, owned := b.shareKey(k)
+	_, err = m.addSharedOffsetWithKey(k, owned, o)
+	if err == nil && owned {
+		b.addToPool(k)
+	}
+	return err
+}
+
 func (b *Builder) parse(str string) (e error) { //parse string as a method call, mostly used for quickly building test cases
 	e = nil
 	_int := func(str string) int64 {
@@ -167,15 +436,15 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 		case INT, UINT, FLOAT:
 			switch maxcapacity {
 			case 0:
-				return newTypedVector(baseType + 10), nil
+				return b.arena.newTypedVector(baseType + 10), nil
 			case 1:
-				return newFixedTypedVector(baseType + 5), nil //Indirect
+				return b.arena.newFixedTypedVector(baseType + 5), nil //Indirect
 			case 2:
-				return newFixedTypedVector(baseType + 15), nil //Tuple
+				return b.arena.newFixedTypedVector(baseType + 15), nil //Tuple
 			case 3:
-				return newFixedTypedVector(baseType + 18), nil //Triple
+				return b.arena.newFixedTypedVector(baseType + 18), nil //Triple
 			case 4:
-				return newFixedTypedVector(baseType + 21), nil //Quad
+				return b.arena.newFixedTypedVector(baseType + 21), nil //Quad
 			default:
 				return nil, fmt.Errorf("fixed vector types of %d capacity not supported", maxcapacity)
 			}
@@ -185,11 +454,11 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			switch baseType {
 			case BOOL:
-				return newTypedVector(VECTOR_BOOL), nil
+				return b.arena.newTypedVector(VECTOR_BOOL), nil
 			case STRING:
-				return newTypedVector(VECTOR_STRING_DEPRECATED), nil
+				return b.arena.newTypedVector(VECTOR_STRING_DEPRECATED), nil
 			case KEY:
-				return newTypedVector(VECTOR_KEY), nil
+				return b.arena.newTypedVector(VECTOR_KEY), nil
 			}
 		default:
 			return nil, fmt.Errorf("vector types of base type %s not supported", baseType.toString())
@@ -238,7 +507,7 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			e = b.registerElementWithOptionalKey(k, newUINT(_uint(args[0])))
 		case "INT":
@@ -247,7 +516,7 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			e = b.registerElementWithOptionalKey(k, newINT(_int(args[0])))
 		case "FLOAT":
@@ -256,16 +525,16 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
-			e = b.registerElementWithOptionalKey(k, newFLOAT(_float(args[0])))
+			e = b.registerElementWithOptionalKey(k, b.newFLOAT(_float(args[0])))
 		case "BOOL":
 			if err := requireArgCount(1); err != nil {
 				return err
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			e = b.registerElementWithOptionalKey(k, newBOOL(_bool(args[0])))
 		case "NULL":
@@ -274,7 +543,7 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			e = b.registerElementWithOptionalKey(k, newNULL())
 		case "KEY":
@@ -283,29 +552,27 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
-			e = b.startWithOptionalKey(k, newKey(_string(args[0])))
-			b.End()
+			e = b.addShareable(k, b.arena.newKey(_string(args[0])))
 		case "STRING":
 			if err := requireArgCount(1); err != nil {
 				return err
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
-			e = b.startWithOptionalKey(k, newFlexString(_string(args[0])))
-			b.End()
+			e = b.addShareable(k, b.arena.newFlexString(_string(args[0])))
 		case "VEC":
 			if err := requireArgCount(0); err != nil {
 				return err
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
-			e = b.startWithOptionalKey(k, newVector())
+			e = b.startWithOptionalKey(k, b.arena.newVector())
 		case "INTVEC":
 			if err := requireArgCount(1); err != nil {
 				return err
@@ -316,7 +583,7 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			e = b.startWithOptionalKey(k, v)
 		case "UINTVEC":
@@ -329,7 +596,7 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			e = b.startWithOptionalKey(k, v)
 		case "FLOATVEC":
@@ -342,7 +609,7 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			e = b.startWithOptionalKey(k, v)
 		case "BOOLVEC":
@@ -351,22 +618,22 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
-			e = b.startWithOptionalKey(k, newTypedVector(VECTOR_BOOL))
+			e = b.startWithOptionalKey(k, b.arena.newTypedVector(VECTOR_BOOL))
 		case "BLOB":
 			if err := requireArgCount(0); err != nil {
 				return err
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			bytes := []byte{}
 			for _, arg := range args {
 				bytes = append(bytes, byte(_uint(arg)))
 			}
-			e = b.startWithOptionalKey(k, newBlob(bytes))
+			e = b.startWithOptionalKey(k, b.arena.newBlob(bytes))
 			b.End()
 		case "STRINGVEC":
 			if err := requireArgCount(0); err != nil {
@@ -374,16 +641,16 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
-			e = b.startWithOptionalKey(k, newTypedVector(VECTOR_STRING_DEPRECATED))
+			e = b.startWithOptionalKey(k, b.arena.newTypedVector(VECTOR_STRING_DEPRECATED))
 		case "KEYVEC":
 			if err := requireArgCount(0); err != nil {
 				return err
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
 			e = b.startWithOptionalKey(k, newKeyVector())
 		case "MAP":
@@ -392,9 +659,9 @@ func (b *Builder) parse(str string) (e error) { //parse string as a method call,
 			}
 			var k *key = nil
 			if m["key"] != "" {
-				k = newKey(m["key"])
+				k = b.arena.newKey(m["key"])
 			}
-			e = b.startWithOptionalKey(k, newFlexMap())
+			e = b.startWithOptionalKey(k, b.arena.newFlexMap())
 		case "END":
 			if err := requireArgCount(0); err != nil {
 				return err
@@ -421,7 +688,7 @@ func (b *Builder) Uint(u uint64) error {
 	return b.registerElement(newUINT(u))
 }
 func (b *Builder) Float(f float64) error {
-	return b.registerElement(newFLOAT(f))
+	return b.registerElement(b.newFLOAT(f))
 }
 func (b *Builder) Bool(l bool) error {
 	return b.registerElement(newBOOL(l))
@@ -433,97 +700,95 @@ func (b *Builder) Null() error {
 //API for vectors & typed vectors
 
 func (b *Builder) StartVector() error {
-	return b.start(newVector())
+	return b.start(b.arena.newVector())
 }
 
 func (b *Builder) StartTypedIntVector() error {
-	return b.start(newTypedVector(VECTOR_INT))
+	return b.start(b.arena.newTypedVector(VECTOR_INT))
 }
 
 func (b *Builder) StartTypedUintVector() error {
-	return b.start(newTypedVector(VECTOR_UINT))
+	return b.start(b.arena.newTypedVector(VECTOR_UINT))
 }
 
 func (b *Builder) StartTypedFloatVector() error {
-	return b.start(newTypedVector(VECTOR_FLOAT))
+	return b.start(b.arena.newTypedVector(VECTOR_FLOAT))
 }
 
 func (b *Builder) StartTypedBoolVector() error {
-	return b.start(newTypedVector(VECTOR_BOOL))
+	return b.start(b.arena.newTypedVector(VECTOR_BOOL))
 }
 
 func (b *Builder) StartBlob(bytes []byte) error {
-	return b.start(newBlob(bytes))
+	return b.start(b.arena.newBlob(bytes))
 }
 
 //API for scalars, tuples, triples, quads
 
 func (b *Builder) StartIntScalar() error {
-	return b.start(newFixedTypedVector(INDIRECT_INT))
+	return b.start(b.arena.newFixedTypedVector(INDIRECT_INT))
 }
 
 func (b *Builder) StartUintScalar() error {
-	return b.start(newFixedTypedVector(INDIRECT_UINT))
+	return b.start(b.arena.newFixedTypedVector(INDIRECT_UINT))
 }
 
 func (b *Builder) StartFloatScalar() error {
-	return b.start(newFixedTypedVector(INDIRECT_FLOAT))
+	return b.start(b.arena.newFixedTypedVector(INDIRECT_FLOAT))
 }
 
 func (b *Builder) StartIntTuple() error {
-	return b.start(newFixedTypedVector(VECTOR_INT2))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_INT2))
 }
 
 func (b *Builder) StartUintTuple() error {
-	return b.start(newFixedTypedVector(VECTOR_UINT2))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_UINT2))
 }
 
 func (b *Builder) StartFloatTuple() error {
-	return b.start(newFixedTypedVector(VECTOR_FLOAT2))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_FLOAT2))
 }
 
 func (b *Builder) StartIntTriple() error {
-	return b.start(newFixedTypedVector(VECTOR_INT3))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_INT3))
 }
 
 func (b *Builder) StartUintTriple() error {
-	return b.start(newFixedTypedVector(VECTOR_UINT3))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_UINT3))
 }
 
 func (b *Builder) StartFloatTriple() error {
-	return b.start(newFixedTypedVector(VECTOR_FLOAT3))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_FLOAT3))
 }
 
 func (b *Builder) StartIntQuad() error {
-	return b.start(newFixedTypedVector(VECTOR_INT4))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_INT4))
 }
 
 func (b *Builder) StartUintQuad() error {
-	return b.start(newFixedTypedVector(VECTOR_UINT4))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_UINT4))
 }
 
 func (b *Builder) StartFloatQuad() error {
-	return b.start(newFixedTypedVector(VECTOR_FLOAT4))
+	return b.start(b.arena.newFixedTypedVector(VECTOR_FLOAT4))
 }
 
 //API for string types
 
 func (b *Builder) StartString() error {
-	return b.start(newFlexString(""))
+	return b.start(b.arena.newFlexString(""))
 }
 
 func (b *Builder) String(str string) error {
-	defer b.End()
-	return b.start(newFlexString(str))
+	return b.addShareable(nil, b.arena.newFlexString(str))
 }
 
 func (b *Builder) StartKey() error {
-	return b.start(newKey(""))
+	return b.start(b.arena.newKey(""))
 }
 
 func (b *Builder) Key(str string) error {
-	defer b.End()
-	return b.start(newKey(str))
+	return b.addShareable(nil, b.arena.newKey(str))
 }
 
 func (b *Builder) Append(str string) error { //maybe other vector types should also support appending?
@@ -539,289 +804,476 @@ func (b *Builder) Append(str string) error { //maybe other vector types should a
 //API for maps
 
 func (b *Builder) StartMap() error {
-	return b.start(newFlexMap())
+	return b.start(b.arena.newFlexMap())
 }
 
 func (b *Builder) StartMapWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFlexMap())
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFlexMap())
+}
+
+//StartMapWithSharedKeys starts a map that points to the key vector of the first map started with the same id, for
+//records whose keys are known up front. A map whose keys turn out to be different gets a key vector of its own. An
+//empty id starts a map like StartMap
+func (b *Builder) StartMapWithSharedKeys(id string) error {
+	m := b.arena.newFlexMap()
+	m.schema = id
+	return b.start(m)
+}
+
+func (b *Builder) StartMapWithSharedKeysWithKey(k string, id string) error {
+	m := b.arena.newFlexMap()
+	m.schema = id
+	return b.startWithKey(b.arena.newKey(k), m)
 }
 
 func (b *Builder) UintWithKey(k string, u uint64) error {
-	return b.registerElementWithKey(newKey(k), newUINT(u))
+	return b.registerElementWithKey(b.arena.newKey(k), newUINT(u))
 }
 
 func (b *Builder) IntWithKey(k string, i int64) error {
-	return b.registerElementWithKey(newKey(k), newINT(i))
+	return b.registerElementWithKey(b.arena.newKey(k), newINT(i))
 }
 
 func (b *Builder) FloatWithKey(k string, f float64) error {
-	return b.registerElementWithKey(newKey(k), newFLOAT(f))
+	return b.registerElementWithKey(b.arena.newKey(k), b.newFLOAT(f))
 }
 
 func (b *Builder) BoolWithKey(k string, l bool) error {
-	return b.registerElementWithKey(newKey(k), newBOOL(l))
+	return b.registerElementWithKey(b.arena.newKey(k), newBOOL(l))
 }
 
 func (b *Builder) NullWithKey(k string) error {
-	return b.registerElementWithKey(newKey(k), newNULL())
+	return b.registerElementWithKey(b.arena.newKey(k), newNULL())
 }
 
 func (b *Builder) StartVectorWithKey(k string) error {
-	return b.startWithKey(newKey(k), newVector())
+	return b.startWithKey(b.arena.newKey(k), b.arena.newVector())
 }
 
 func (b *Builder) StartTypedIntVectorWithKey(k string) error {
-	return b.startWithKey(newKey(k), newTypedVector(VECTOR_INT))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newTypedVector(VECTOR_INT))
 }
 
 func (b *Builder) StartTypedUintVectorWithKey(k string) error {
-	return b.startWithKey(newKey(k), newTypedVector(VECTOR_UINT))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newTypedVector(VECTOR_UINT))
 }
 
 func (b *Builder) StartTypedFloatVectorWithKey(k string) error {
-	return b.startWithKey(newKey(k), newTypedVector(VECTOR_FLOAT))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newTypedVector(VECTOR_FLOAT))
 }
 
 func (b *Builder) StartTypedBoolVectorWithKey(k string) error {
-	return b.startWithKey(newKey(k), newTypedVector(VECTOR_BOOL))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newTypedVector(VECTOR_BOOL))
 }
 
 func (b *Builder) StartBlobWithKey(k string, bytes []byte) error {
-	return b.startWithKey(newKey(k), newBlob(bytes))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newBlob(bytes))
 }
 
 //API for scalars, tuples, triples, quads
 
 func (b *Builder) StartIntScalarWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(INDIRECT_INT))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(INDIRECT_INT))
 }
 
 func (b *Builder) StartUintScalarWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(INDIRECT_UINT))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(INDIRECT_UINT))
 }
 
 func (b *Builder) StartFloatScalarWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(INDIRECT_FLOAT))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(INDIRECT_FLOAT))
 }
 
 func (b *Builder) StartIntTupleWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_INT2))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_INT2))
 }
 
 func (b *Builder) StartUintTupleWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_UINT2))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_UINT2))
 }
 
 func (b *Builder) StartFloatTupleWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_FLOAT2))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_FLOAT2))
 }
 
 func (b *Builder) StartIntTripleWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_INT3))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_INT3))
 }
 
 func (b *Builder) StartUintTripleWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_UINT3))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_UINT3))
 }
 
 func (b *Builder) StartFloatTripleWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_FLOAT3))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_FLOAT3))
 }
 
 func (b *Builder) StartIntQuadWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_INT4))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_INT4))
 }
 
 func (b *Builder) StartUintQuadWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_UINT4))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_UINT4))
 }
 
 func (b *Builder) StartFloatQuadWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFixedTypedVector(VECTOR_FLOAT4))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFixedTypedVector(VECTOR_FLOAT4))
 }
 
 func (b *Builder) StartStringWithKey(k string) error {
-	return b.startWithKey(newKey(k), newFlexString(""))
+	return b.startWithKey(b.arena.newKey(k), b.arena.newFlexString(""))
 }
 
 func (b *Builder) StringWithKey(k string, str string) error {
-	defer b.End()
-	return b.startWithKey(newKey(k), newFlexString(str))
+	return b.addShareable(b.arena.newKey(k), b.arena.newFlexString(str))
 }
 
 //Kinda silly
 
 // func (b *Builder) StartKeyWithKey(k string) error {
-// 	return b.startWithKey(newKey(k), newKey(""))
+// 	return b.startWithKey(b.arena.newKey(k), b.arena.newKey(""))
 // }
 
 // func (b *Builder) KeyWithKey(k string, str string) error {
 // 	defer b.End()
-// 	return b.startWithKey(newKey(k), newKey(str))
+// 	return b.startWithKey(b.arena.newKey(k), b.arena.newKey(str))
 // }
 
 //Auto-Building
 
+//An UnsupportedValueError is returned by AutoBuild and Marshal for values that can not be encoded, such as cyclic
+//data structures
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "flexbuffers: unsupported value: " + e.Str
+}
+
+//autoBuild only looks for cycles once it is nested this deep in pointers, maps and slices, like encoding/json
+const startDetectingCyclesAfter = 1000
+
+//identifies a pointer, map or slice while looking for cycles
+type visit struct {
+	ptr unsafe.Pointer
+	len int
+}
+
+func visitOf(v reflect.Value) visit {
+	if v.Kind() == reflect.Slice {
+		return visit{v.UnsafePointer(), v.Len()}
+	}
+	return visit{v.UnsafePointer(), 0}
+}
+
+//called before building the content of a pointer, map or slice, fails if it is already being built
+func (b *Builder) enterAuto(v reflect.Value) error {
+	b.autoDepth++
+	if b.autoDepth <= startDetectingCyclesAfter {
+		return nil
+	}
+	p := visitOf(v)
+	if _, ok := b.autoSeen[p]; ok {
+		b.autoDepth--
+		return &UnsupportedValueError{Value: v, Str: fmt.Sprintf("encountered a cycle via %s", v.Type().String())}
+	}
+	if b.autoSeen == nil {
+		b.autoSeen = map[visit]struct{}{}
+	}
+	b.autoSeen[p] = struct{}{}
+	return nil
+}
+
+func (b *Builder) leaveAuto(v reflect.Value) {
+	if b.autoDepth > startDetectingCyclesAfter {
+		delete(b.autoSeen, visitOf(v))
+	}
+	b.autoDepth--
+}
+
+//AutoBuild adds an arbitrary Go value to the buffer. Structs (see the `flexbuffers:"name,omitempty"` field tag) and maps
+//with string keys become maps, slices and arrays become vectors - typed vectors for numeric and bool element types,
+//fixed typed vectors for numeric arrays of length 1 to 4. Nil pointers and interfaces become NULL. Cyclic data
+//structures are reported with an *UnsupportedValueError
 func (b *Builder) AutoBuild(item interface{}) error {
-	var auto func(*key, interface{}) error
-
-	auto = func(k *key, item interface{}) error {
-		slicetype := false
-		switch x := item.(type) {
-		case uint:
-			return b.registerElementWithOptionalKey(k, newUINT(uint64(x)))
-		case uint8:
-			return b.registerElementWithOptionalKey(k, newUINT(uint64(x)))
-		case uint16:
-			return b.registerElementWithOptionalKey(k, newUINT(uint64(x)))
-		case uint32:
-			return b.registerElementWithOptionalKey(k, newUINT(uint64(x)))
-		case uint64:
-			return b.registerElementWithOptionalKey(k, newUINT(x))
-		case int:
-			return b.registerElementWithOptionalKey(k, newINT(int64(x)))
-		case int8:
-			return b.registerElementWithOptionalKey(k, newINT(int64(x)))
-		case int16:
-			return b.registerElementWithOptionalKey(k, newINT(int64(x)))
-		case int32:
-			return b.registerElementWithOptionalKey(k, newINT(int64(x)))
-		case int64:
-			return b.registerElementWithOptionalKey(k, newINT(x))
-		case float32:
-			return b.registerElementWithOptionalKey(k, newFLOAT(float64(x)))
-		case float64:
-			return b.registerElementWithOptionalKey(k, newFLOAT(x))
-		case bool:
-			return b.registerElementWithOptionalKey(k, newBOOL(x))
-		case string:
-			defer b.End()
-			return b.startWithOptionalKey(k, newFlexString(x))
-		case map[string]interface{}:
-			defer b.End()
-			b.startWithOptionalKey(k, newFlexMap())
-			for k, v := range x {
-				var K *key = nil
-				if k != "" {
-					K = newKey(k)
-				}
-				auto(K, v)
-			}
-		case []interface{}, []string:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newVector()); err != nil {
-				return err
-			}
-			slicetype = true
-		case []uint, []uint8, []uint16, []uint32, []uint64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newTypedVector(VECTOR_UINT)); err != nil {
-				return err
-			}
-			slicetype = true
-		case []int, []int8, []int16, []int32, []int64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newTypedVector(VECTOR_INT)); err != nil {
-				return err
-			}
-			slicetype = true
-		case []float32, []float64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newTypedVector(VECTOR_FLOAT)); err != nil {
-				return err
-			}
-			slicetype = true
-		case []bool:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newTypedVector(VECTOR_BOOL)); err != nil {
-				return err
-			}
-			slicetype = true
-		case [1]uint8, [1]uint16, [1]uint32, [1]uint64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(INDIRECT_UINT)); err != nil {
-				return err
-			}
-			slicetype = true
-		case [1]int8, [1]int16, [1]int32, [1]int64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(INDIRECT_INT)); err != nil {
-				return err
-			}
-			slicetype = true
-		case [1]float32, [1]float64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(INDIRECT_FLOAT)); err != nil {
+	return b.autoBuild(nil, reflect.ValueOf(item))
+}
+
+func (b *Builder) autoBuild(k *key, v reflect.Value) error {
+	if !v.IsValid() {
+		return b.registerElementWithOptionalKey(k, newNULL())
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !v.IsNil() {
+			if err := b.enterAuto(v); err != nil {
 				return err
 			}
-			slicetype = true
-		case [2]uint8, [2]uint16, [2]uint32, [2]uint64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_UINT2)); err != nil {
+			defer b.leaveAuto(v)
+		}
+	}
+	if m, ok := marshaler(v); ok {
+		return b.buildMarshaler(k, m, v.Type())
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return b.registerElementWithOptionalKey(k, newNULL())
+		}
+		return b.autoBuild(k, v.Elem())
+	case reflect.Bool:
+		return b.registerElementWithOptionalKey(k, newBOOL(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return b.registerElementWithOptionalKey(k, newINT(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return b.registerElementWithOptionalKey(k, newUINT(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return b.registerElementWithOptionalKey(k, b.newFLOAT(v.Float()))
+	case reflect.String:
+		return b.addShareable(k, b.arena.newFlexString(v.String()))
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unable to auto-build map with non-string key type %s", v.Type().Key().String())
+		}
+		if err := b.startWithOptionalKey(k, b.arena.newFlexMap()); err != nil {
+			return err
+		}
+		if v.Len() == 0 {
+			b.End()
+			return nil
+		}
+		//keys are added in sorted order so that the output does not depend on map iteration order
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, mk := range keys {
+			if err := b.autoBuild(b.arena.newKey(mk.String()), v.MapIndex(mk)); err != nil {
 				return err
 			}
-			slicetype = true
-		case [2]int8, [2]int16, [2]int32, [2]int64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_INT2)); err != nil {
-				return err
+		}
+		b.End()
+		return nil
+	case reflect.Struct:
+		if err := b.startWithOptionalKey(k, b.arena.newFlexMap()); err != nil {
+			return err
+		}
+		for _, f := range typeFields(v.Type()) {
+			fv, ok := fieldByIndexNoAlloc(v, f.index)
+			if !ok || f.omitEmpty && isEmptyValue(fv) {
+				continue
 			}
-			slicetype = true
-		case [2]float32, [2]float64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_FLOAT2)); err != nil {
+			if err := b.autoBuild(b.arena.newKey(f.name), fv); err != nil {
 				return err
 			}
-			slicetype = true
-		case [3]uint8, [3]uint16, [3]uint32, [3]uint64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_UINT3)); err != nil {
+		}
+		b.End()
+		return nil
+	case reflect.Slice, reflect.Array:
+		if err := b.startWithOptionalKey(k, b.autoVector(v.Type())); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := b.autoBuild(nil, v.Index(i)); err != nil {
 				return err
 			}
-			slicetype = true
-		case [3]int8, [3]int16, [3]int32, [3]int64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_INT3)); err != nil {
+		}
+		b.End()
+		return nil
+	}
+	return fmt.Errorf("unable to auto-build type %s", v.Type().String())
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+func marshaler(v reflect.Value) (Marshaler, bool) {
+	if v.Kind() == reflect.Ptr && v.IsNil() || !v.CanInterface() {
+		return nil, false
+	}
+	if v.Type().Implements(marshalerType) {
+		return v.Interface().(Marshaler), true
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(marshalerType) {
+		return v.Addr().Interface().(Marshaler), true
+	}
+	return nil, false
+}
+
+func (b *Builder) buildMarshaler(k *key, m Marshaler, t reflect.Type) error {
+	head := b.getHead()
+	headIndex := b.headIndex
+	count := head.elemsCount()
+	b.pendingKey = k
+	err := m.MarshalFlexBuffer(b)
+	b.pendingKey = nil
+	if err != nil {
+		return fmt.Errorf("error calling MarshalFlexBuffer for type %s: %w", t.String(), err)
+	}
+	if b.headIndex != headIndex || b.getHead() != head {
+		return fmt.Errorf("MarshalFlexBuffer for type %s left unfinished structures in the builder", t.String())
+	}
+	if head.elemsCount() != count+1 {
+		return fmt.Errorf("MarshalFlexBuffer for type %s added %d values, expected exactly 1", t.String(), head.elemsCount()-count)
+	}
+	return nil
+}
+
+//picks the vector type for a slice or array type
+func (b *Builder) autoVector(t reflect.Type) iStructure {
+	if t.Elem().Implements(marshalerType) || reflect.PtrTo(t.Elem()).Implements(marshalerType) {
+		//elements build themselves, so their type is not known up front
+		return b.arena.newVector()
+	}
+	var baseType VarType
+	switch t.Elem().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		baseType = INT
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		baseType = UINT
+	case reflect.Float32, reflect.Float64:
+		baseType = FLOAT
+	case reflect.Bool:
+		return b.arena.newTypedVector(VECTOR_BOOL)
+	default:
+		return b.arena.newVector()
+	}
+	if t.Kind() == reflect.Array {
+		switch t.Len() {
+		case 1:
+			return b.arena.newFixedTypedVector(baseType + 5) //Indirect
+		case 2:
+			return b.arena.newFixedTypedVector(baseType + 15) //Tuple
+		case 3:
+			return b.arena.newFixedTypedVector(baseType + 18) //Triple
+		case 4:
+			return b.arena.newFixedTypedVector(baseType + 21) //Quad
+		}
+	}
+	return b.arena.newTypedVector(baseType + 10)
+}
+
+//Copying
+
+//AddRef adds a copy of the value referenced by r, which may belong to any buffer. Strings, blobs, vectors, typed
+//vectors, maps and their key vectors are copied with their types, offsets and byte widths are computed again for the
+//new buffer. Shared strings and keys of r are pooled according to the options of the builder
+func (b *Builder) AddRef(r Ref) error {
+	return b.addRef(nil, r, 0)
+}
+
+//AddRefWithKey adds a copy of the value referenced by r to the current map, under the key k. See AddRef
+func (b *Builder) AddRefWithKey(k string, r Ref) error {
+	return b.addRef(b.arena.newKey(k), r, 0)
+}
+
+//adds a copy of the value referenced by r, under the key k if it is part of a map. Types are kept, byte widths are
+//chosen again by the builder and deprecated vectors of strings become untyped vectors
+func (b *Builder) addRef(k *key, r Ref, depth int) error {
+	if err := r.checkDepth(depth); err != nil {
+		return err
+	}
+	vType := r.context.ItemVarType()
+	switch true {
+	case vType == NULL:
+		return b.registerElementWithOptionalKey(k, newNULL())
+	case vType == BOOL:
+		l, err := r.Bool()
+		if err != nil {
+			return err
+		}
+		return b.registerElementWithOptionalKey(k, newBOOL(l))
+	case vType == INT:
+		i, err := r.Int()
+		if err != nil {
+			return err
+		}
+		return b.registerElementWithOptionalKey(k, newINT(i))
+	case vType == UINT:
+		u, err := r.Uint()
+		if err != nil {
+			return err
+		}
+		return b.registerElementWithOptionalKey(k, newUINT(u))
+	case vType == FLOAT:
+		f, err := r.Float()
+		if err != nil {
+			return err
+		}
+		return b.registerElementWithOptionalKey(k, b.newFLOAT(f))
+	case vType == STRING, vType == KEY && (k != nil || b.pendingKey != nil):
+		//keys can only be added to vectors, inside maps they are copied as strings
+		s, err := r.String()
+		if err != nil {
+			return err
+		}
+		return b.addShareable(k, b.arena.newFlexString(s))
+	case vType == KEY:
+		s, err := r.Key()
+		if err != nil {
+			return err
+		}
+		return b.addShareable(nil, b.arena.newKey(s))
+	case vType == BLOB:
+		data, err := r.Blob()
+		if err != nil {
+			return err
+		}
+		if err := b.startWithOptionalKey(k, b.arena.newBlob(data)); err != nil {
+			return err
+		}
+		b.End()
+		return nil
+	case vType == MAP:
+		keys, err := r.KeyVector()
+		if err != nil {
+			return err
+		}
+		if err := b.startWithOptionalKey(k, b.arena.newFlexMap()); err != nil {
+			return err
+		}
+		for i := int64(0); i < int64(r.item_count); i++ {
+			name, err := keys.keyAt(i)
+			if err != nil {
 				return err
 			}
-			slicetype = true
-		case [3]float32, [3]float64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_FLOAT3)); err != nil {
+			val_ref, err := r.Index(i)
+			if err != nil {
 				return err
 			}
-			slicetype = true
-		case [4]uint8, [4]uint16, [4]uint32, [4]uint64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_UINT4)); err != nil {
+			if err := b.addRef(b.arena.newKey(name), val_ref, depth+1); err != nil {
 				return err
 			}
-			slicetype = true
-		case [4]int8, [4]int16, [4]int32, [4]int64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_INT4)); err != nil {
+		}
+		b.End()
+		return nil
+	case isVector(vType):
+		var o iStructure
+		switch true {
+		case vType == VECTOR_KEY:
+			o = newKeyVector()
+		case vType == VECTOR_STRING_DEPRECATED:
+			o = b.arena.newVector()
+		case isFixedTypedVector(vType):
+			o = b.arena.newFixedTypedVector(vType)
+		case isTypedVector(vType):
+			o = b.arena.newTypedVector(vType)
+		default:
+			o = b.arena.newVector()
+		}
+		if err := b.startWithOptionalKey(k, o); err != nil {
+			return err
+		}
+		for i := int64(0); i < int64(r.item_count); i++ {
+			item_ref, err := r.Index(i)
+			if err != nil {
 				return err
 			}
-			slicetype = true
-		case [4]float32, [4]float64:
-			defer b.End()
-			if err := b.startWithOptionalKey(k, newFixedTypedVector(VECTOR_FLOAT4)); err != nil {
+			if err := b.addRef(nil, item_ref, depth+1); err != nil {
 				return err
 			}
-			slicetype = true
-		default:
-			return fmt.Errorf("unable to auto-build type %T", x)
-		}
-		if slicetype {
-			v := reflect.ValueOf(item)
-			for i := 0; i < v.Len(); i++ {
-				if err := auto(nil, v.Index(i).Interface()); err != nil {
-					return err
-				}
-			}
 		}
+		b.End()
 		return nil
 	}
-	return auto(nil, item)
-
+	return r.corrupt(r.index_0, "unable to copy object of type %s", vType.toString())
 }
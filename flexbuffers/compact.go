@@ -0,0 +1,127 @@
+package flexbuffers
+
+//Options of Compact. The zero value only narrows byte widths
+type CompactOptions struct {
+	ShareStrings    bool //identical strings are written once, see BuilderOptions
+	ShareKeys       bool //identical keys are written once, see BuilderOptions
+	ShareKeyVectors bool //maps with identical keys share their key vector, see BuilderOptions
+	TypedVectors    bool //untyped vectors whose items are all INT, UINT, FLOAT, BOOL or KEY become typed vectors
+}
+
+//Compact writes the flexbuffer in buff again with the narrowest byte widths that can hold its values, so that buffers
+//produced with wide or fixed widths get smaller. Values keep their types, except for the untyped vectors converted by
+//CompactOptions.TypedVectors, and the result is Equal to the input
+func Compact(buff []byte, opts CompactOptions) ([]byte, error) {
+	r, err := Root(buff)
+	if err != nil {
+		return nil, err
+	}
+	b := NewBuilderWithOptions(BuilderOptions{
+		ShareStrings:    opts.ShareStrings,
+		ShareKeys:       opts.ShareKeys,
+		ShareKeyVectors: opts.ShareKeyVectors,
+	})
+	if err := b.compact(nil, r, opts, 0); err != nil {
+		return nil, err
+	}
+	return b.Bytes()
+}
+
+//adds a copy of the value like addRef, converting the untyped vectors inside it if asked to
+func (b *Builder) compact(k *key, r Ref, opts CompactOptions, depth int) error {
+	if !opts.TypedVectors {
+		return b.addRef(k, r, depth)
+	}
+	if err := r.checkDepth(depth); err != nil {
+		return err
+	}
+	vType := r.context.ItemVarType()
+	switch true {
+	case vType == MAP:
+		keys, err := r.KeyVector()
+		if err != nil {
+			return err
+		}
+		if err := b.startWithOptionalKey(k, b.arena.newFlexMap()); err != nil {
+			return err
+		}
+		for i := int64(0); i < int64(r.item_count); i++ {
+			name, err := keys.keyAt(i)
+			if err != nil {
+				return err
+			}
+			val_ref, err := r.Index(i)
+			if err != nil {
+				return err
+			}
+			if err := b.compact(b.arena.newKey(name), val_ref, opts, depth+1); err != nil {
+				return err
+			}
+		}
+		b.End()
+		return nil
+	case vType == VECTOR:
+		typed, err := r.typedVectorType()
+		if err != nil {
+			return err
+		}
+		var o iStructure
+		switch true {
+		case typed == VECTOR_KEY:
+			o = newKeyVector()
+		case typed != VECTOR:
+			o = b.arena.newTypedVector(typed)
+		default:
+			o = b.arena.newVector()
+		}
+		if err := b.startWithOptionalKey(k, o); err != nil {
+			return err
+		}
+		for i := int64(0); i < int64(r.item_count); i++ {
+			item_ref, err := r.Index(i)
+			if err != nil {
+				return err
+			}
+			if err := b.compact(nil, item_ref, opts, depth+1); err != nil {
+				return err
+			}
+		}
+		b.End()
+		return nil
+	}
+	return b.addRef(k, r, depth)
+}
+
+//returns the typed vector type that can hold all the items of an untyped vector, or VECTOR if there is none
+func (r Ref) typedVectorType() (VarType, error) {
+	if r.item_count == 0 {
+		return VECTOR, nil
+	}
+	var first VarType
+	for i := int64(0); i < int64(r.item_count); i++ {
+		con, err := r.getItemContext(i)
+		if err != nil {
+			return 0, err
+		}
+		vType := con.ItemVarType()
+		if i == 0 {
+			first = vType
+		}
+		if vType != first {
+			return VECTOR, nil
+		}
+	}
+	switch first {
+	case INT:
+		return VECTOR_INT, nil
+	case UINT:
+		return VECTOR_UINT, nil
+	case FLOAT:
+		return VECTOR_FLOAT, nil
+	case BOOL:
+		return VECTOR_BOOL, nil
+	case KEY:
+		return VECTOR_KEY, nil
+	}
+	return VECTOR, nil
+}
@@ -0,0 +1,78 @@
+package flexbuffers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	//a buffer with wide widths and repeated strings
+	wide := NewBuilderWithOptions(BuilderOptions{ForceMinBitWidth: W64, Floats: FloatAlways64})
+	require.NoError(t, wide.StartVector())
+	for i := 0; i < 3; i++ {
+		require.NoError(t, wide.StartMap())
+		require.NoError(t, wide.StringWithKey("city", "Springfield"))
+		require.NoError(t, wide.IntWithKey("id", int64(i)))
+		require.NoError(t, wide.FloatWithKey("score", 0.5))
+		require.NoError(t, wide.StartVectorWithKey("tags"))
+		require.NoError(t, wide.Int(1))
+		require.NoError(t, wide.Int(-2))
+		wide.End()
+		require.NoError(t, wide.StartTypedUintVectorWithKey("u"))
+		require.NoError(t, wide.Uint(7))
+		wide.End()
+		wide.End()
+	}
+	require.NoError(t, wide.StartVector())
+	require.NoError(t, wide.Key("a"))
+	require.NoError(t, wide.Key("b"))
+	wide.End()
+	require.NoError(t, wide.StartVector())
+	require.NoError(t, wide.Int(1))
+	require.NoError(t, wide.Uint(2))
+	wide.End()
+	wide.End()
+	buff, err := wide.Bytes()
+	require.NoError(t, err)
+	in := *NewRef(buff)
+
+	var sizes []int
+	for _, opts := range []CompactOptions{
+		{},
+		{ShareStrings: true, ShareKeys: true},
+		{ShareStrings: true, ShareKeys: true, TypedVectors: true},
+		{ShareStrings: true, ShareKeys: true, TypedVectors: true, ShareKeyVectors: true},
+	} {
+		compacted, err := Compact(buff, opts)
+		require.NoError(t, err)
+		require.NoError(t, Verify(compacted, VerifyOptions{}))
+		out := *NewRef(compacted)
+		require.True(t, Equal(in, out), "%+v", opts)
+		require.Equal(t, in.Hash64(), out.Hash64())
+		sizes = append(sizes, len(compacted))
+
+		//typed vectors keep their types, untyped vectors are converted only if asked to
+		u, err := out.Lookup("/0/u")
+		require.NoError(t, err)
+		require.Equal(t, VarType(VECTOR_UINT), u.context.ItemVarType())
+		expected := []VarType{VECTOR, VECTOR, VECTOR}
+		if opts.TypedVectors {
+			expected = []VarType{VECTOR_INT, VECTOR_KEY, VECTOR}
+		}
+		for i, path := range []string{"/0/tags", "/3", "/4"} {
+			v, err := out.Lookup(path)
+			require.NoError(t, err)
+			require.Equal(t, expected[i], v.context.ItemVarType(), path)
+		}
+	}
+	require.Less(t, sizes[0], len(buff)/2)
+	require.Less(t, sizes[1], sizes[0])
+	require.Less(t, sizes[2], sizes[1])
+	require.Less(t, sizes[3], sizes[2])
+
+	//corrupt buffers are reported
+	buff[len(buff)-4] = 0xff
+	_, err = Compact(buff, CompactOptions{})
+	require.ErrorIs(t, err, ErrCorrupt)
+}
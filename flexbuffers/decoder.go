@@ -18,84 +18,173 @@ type Ref struct { //interprets raw data
 //TODO: is int64 too big?
 //TODO convert all from  Ref to Ref
 //for finding null bytes : package bytes, function IndexByte
-func (r Ref) getItemAsRef(item_index int64, con context) Ref {
+func (r Ref) getItemAsRef(item_index int64, con context) (Ref, error) {
 	if !r.IsIterable() {
-		panic(fmt.Sprintf("flexbuffers object of type %s is not iterable", r.context.ItemVarType().toString()))
+		return Ref{}, r.mismatch("object of type %s is not iterable", r.context.ItemVarType().toString())
 	}
 	if !r.InsideBounds(item_index) {
-		panic(fmt.Sprintf("out of bounds, index %d out of %d", item_index, r.item_count))
+		return Ref{}, r.outOfBounds(item_index)
 	}
 	abs_offset := r.absItemOffset(item_index)
-	bWidth := B(r.context.ItemByteSize())
 	if !isInline(con.ItemVarType()) {
-		abs_offset -= bytesAsUint(r.getBytes(abs_offset, bWidth)...)
+		off, err := r.readUint(abs_offset, B(r.context.ItemByteSize()))
+		if err != nil {
+			return Ref{}, err
+		}
+		if off > abs_offset {
+			return Ref{}, r.corrupt(abs_offset, "offset %d points before the start of the buffer", off)
+		}
+		abs_offset -= off
 	}
-	r = Ref{buffer: r.buffer, index_0: abs_offset, context: con} //TODO: limit Ref access to flexbuffer
-	r.item_count = r.itemCount()
-	return r
+	item := Ref{buffer: r.buffer, index_0: abs_offset, context: con} //TODO: limit Ref access to flexbuffer
+	var err error
+	item.item_count, err = item.itemCount()
+	return item, err
 }
 
-func NewRef(buff []byte) *Ref {
+//Root returns a reference to the root of the flexbuffer in buff. It only checks the root itself, untrusted input
+//should be checked with Verify first
+func Root(buff []byte) (Ref, error) {
 	n := len(buff)
-	bWidth := bytesAsUint(buff[n-1])
-	con := context(bytesAsUint(buff[n-2]))
-	index_0 := uint64(n - 2 - int(bWidth))
-	var r *Ref
-	if !isInline(con.ItemVarType()) {
-		index_0 -= bytesAsUint(buff[index_0 : n-2]...)
+	r := Ref{buffer: buff}
+	if n < 3 {
+		return Ref{}, r.corrupt(0, "buffer of %d byte(s) is too short to hold a flexbuffer", n)
+	}
+	bWidth := uint64(buff[n-1])
+	if !isValidWidth(bWidth) || bWidth > uint64(n-2) {
+		return Ref{}, r.corrupt(uint64(n-1), "invalid root byte width %d", bWidth)
+	}
+	con := context(buff[n-2])
+	if !isValidType(con.ItemVarType()) {
+		return Ref{}, r.corrupt(uint64(n-2), "unknown type code %d", uint8(con.ItemVarType()))
+	}
+	index_0 := uint64(n-2) - bWidth
+	if isInline(con.ItemVarType()) {
+		//inline values are read with the width of the root
+		con = Pack(con.ItemVarType(), b(int(bWidth)))
+	} else {
+		off := bytesAsUint(buff[index_0 : n-2]...)
+		if off > index_0 {
+			return Ref{}, r.corrupt(index_0, "offset %d points before the start of the buffer", off)
+		}
+		index_0 -= off
+	}
+	r = Ref{buff, con, index_0, 0}
+	var err error
+	if r.item_count, err = r.itemCount(); err != nil {
+		return Ref{}, err
 	}
-	r = &Ref{buff, con, index_0, 0}
-	r.item_count = r.itemCount()
-	return r
+	return r, nil
 }
 
-func (r Ref) InsideBounds(item_index int64) bool {
-	return item_index < int64(r.item_count)
+//NewRef returns a reference to the root of the flexbuffer in buff, like Root. It panics with a *RefError if the root
+//can not be read
+func NewRef(buff []byte) *Ref {
+	r, err := Root(buff)
+	if err != nil {
+		panic(err)
+	}
+	return &r
 }
 
-func (r Ref) getBytes(abs_offset uint64, bytes uint64) []byte {
-	return r.buffer[abs_offset : abs_offset+bytes]
+func (r Ref) InsideBounds(item_index int64) bool {
+	return item_index >= 0 && item_index < int64(r.item_count)
 }
 
-func (r Ref) relItemOffset(item_index int64) int64 {
-	return int64(r.index_0 - uint64(item_index*int64(B(r.context.ItemByteSize()))))
+//returns the given number of bytes starting at abs_offset
+func (r Ref) getBytes(abs_offset uint64, bytes uint64) ([]byte, error) {
+	n := uint64(len(r.buffer))
+	if abs_offset > n || bytes > n-abs_offset {
+		return nil, r.corrupt(abs_offset, "%d byte(s) out of bounds of a buffer of %d bytes", bytes, n)
+	}
+	return r.buffer[abs_offset : abs_offset+bytes], nil
 }
 
-func (r Ref) absItemOffset(item_index int64) uint64 {
-	return r.absOffset(r.relItemOffset(item_index))
+//reads an unsigned integer of 1, 2, 4 or 8 bytes
+func (r Ref) readUint(abs_offset uint64, bWidth uint64) (uint64, error) {
+	bytes, err := r.getBytes(abs_offset, bWidth)
+	if err != nil {
+		return 0, err
+	}
+	return bytesAsUint(bytes...), nil
 }
 
-func (r Ref) absOffset(rel_offset int64) uint64 {
-	i := r.index_0 - uint64(rel_offset)
-	return i
+func (r Ref) absItemOffset(item_index int64) uint64 {
+	return r.index_0 + uint64(item_index)*B(r.context.ItemByteSize())
 }
 
-func (r Ref) getItemContext(item_index int64) context {
-	rel_offset := r.relItemOffset(item_index) + item_index
-	abs_offset := r.absOffset(rel_offset)
-	con := r.buffer[abs_offset]
-	return context(con)
+//untyped vectors and maps store a packed type for every item right after the items
+func (r Ref) getItemContext(item_index int64) (context, error) {
+	abs_offset := r.absItemOffset(int64(r.item_count)) + uint64(item_index)
+	bytes, err := r.getBytes(abs_offset, 1)
+	if err != nil {
+		return 0, err
+	}
+	con := context(bytes[0])
+	if !isValidType(con.ItemVarType()) {
+		return 0, r.corrupt(abs_offset, "unknown type code %d", uint8(con.ItemVarType()))
+	}
+	if isInline(con.ItemVarType()) {
+		//inline items are read with the width of the parent
+		con = Pack(con.ItemVarType(), r.context.ItemByteSize())
+	}
+	return con, nil
 }
 
-func (r Ref) itemCount() uint64 {
-	switch r.context.ItemVarType() {
-	case INT, UINT, FLOAT, BOOL, NULL, INDIRECT_UINT, INDIRECT_INT, INDIRECT_FLOAT:
-		return 1
-	case VECTOR_INT2, VECTOR_UINT2, VECTOR_FLOAT2:
+//number of items of tuples, triples and quads, 1 for indirect scalars
+func fixedItemCount(vType VarType) uint64 {
+	switch true {
+	case isTuple(vType):
 		return 2
-	case VECTOR_INT3, VECTOR_UINT3, VECTOR_FLOAT3:
+	case isTriple(vType):
 		return 3
-	case VECTOR_INT4, VECTOR_UINT4, VECTOR_FLOAT4:
+	case isQuad(vType):
 		return 4
-	case VECTOR, MAP, BLOB, STRING:
-		rel_offset := r.relItemOffset(-1)
-		abs_offset := r.absOffset(rel_offset)
-		size := r.buffer[abs_offset]
-		return uint64(size)
-	case KEY:
-		return uint64(bytes.IndexByte(r.buffer[r.index_0:], 0) + 1)
 	}
-	panic("an unexpected error has occured")
+	return 1
+}
+
+//returns the number of items, after checking that they fit in the buffer
+func (r Ref) itemCount() (uint64, error) {
+	vType := r.context.ItemVarType()
+	bWidth := B(r.context.ItemByteSize())
+	var count uint64
+	switch true {
+	case isInline(vType), isFixedTypedVector(vType):
+		count = fixedItemCount(vType)
+	case vType == KEY:
+		n := uint64(len(r.buffer))
+		if r.index_0 < n {
+			if i := bytes.IndexByte(r.buffer[r.index_0:], 0); i >= 0 {
+				return uint64(i), nil
+			}
+		}
+		return 0, r.corrupt(r.index_0, "key is not 0-terminated")
+	case vType == MAP, isVector(vType), isBlobLike(vType):
+		//size prefix is stored right before the first item, with the width of the items
+		if r.index_0 < bWidth {
+			return 0, r.corrupt(r.index_0, "size prefix out of bounds")
+		}
+		var err error
+		if count, err = r.readUint(r.index_0-bWidth, bWidth); err != nil {
+			return 0, err
+		}
+		switch true {
+		case isBlobLike(vType):
+			bWidth = 1
+		case vType == MAP, vType == VECTOR:
+			bWidth++ //packed item types
+		}
+	default:
+		return 0, r.corrupt(r.index_0, "unknown type %s", vType.toString())
+	}
+	if count > uint64(len(r.buffer)) {
+		return 0, r.corrupt(r.index_0, "size %d exceeds the buffer", count)
+	}
+	if _, err := r.getBytes(r.index_0, count*bWidth); err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
 func (r Ref) IsUint() bool {
@@ -122,14 +211,12 @@ func bytesAsUint(bytes ...byte) uint64 {
 	return u*/
 }
 
+//Uint reads a UINT or an INDIRECT_UINT
 func (r Ref) Uint() (uint64, error) {
-	if !r.IsUint() {
-		return 0, fmt.Errorf("flexbuffers object of type %s can not be converted to uint", r.context.ItemVarType().toString())
+	if !r.IsUint() && r.context.ItemVarType() != INDIRECT_UINT {
+		return 0, r.mismatch("object of type %s can not be converted to uint", r.context.ItemVarType().toString())
 	}
-
-	bWidth := B(r.context.ItemByteSize())
-	abs_offset := r.index_0
-	return bytesAsUint(r.getBytes(abs_offset, bWidth)...), nil
+	return r.readUint(r.index_0, B(r.context.ItemByteSize()))
 }
 
 func (r Ref) IsInt() bool {
@@ -155,13 +242,16 @@ func bytesAsInt(bytes ...byte) int64 {
 	return i
 }
 
+//Int reads an INT or an INDIRECT_INT
 func (r Ref) Int() (int64, error) {
-	if !r.IsInt() {
-		return 0, fmt.Errorf("flexbuffers object of type %s can not be converted to int", r.context.ItemVarType().toString())
+	if !r.IsInt() && r.context.ItemVarType() != INDIRECT_INT {
+		return 0, r.mismatch("object of type %s can not be converted to int", r.context.ItemVarType().toString())
 	}
-	abs_offset := r.index_0
-	bWidth := B(r.context.ItemByteSize())
-	return bytesAsInt(r.getBytes(abs_offset, bWidth)...), nil
+	bytes, err := r.getBytes(r.index_0, B(r.context.ItemByteSize()))
+	if err != nil {
+		return 0, err
+	}
+	return bytesAsInt(bytes...), nil
 }
 
 func (r Ref) IsBool() bool {
@@ -174,9 +264,13 @@ func bytesAsBool(bytes ...byte) bool {
 
 func (r Ref) Bool() (bool, error) {
 	if !r.IsBool() {
-		return false, fmt.Errorf("flexbuffers object of type %s can not be converted to bool", r.context.ItemVarType().toString())
+		return false, r.mismatch("object of type %s can not be converted to bool", r.context.ItemVarType().toString())
+	}
+	bytes, err := r.getBytes(r.index_0, 1)
+	if err != nil {
+		return false, err
 	}
-	return bytesAsBool(r.buffer[r.index_0]), nil
+	return bytesAsBool(bytes...), nil
 }
 
 func (r Ref) IsFloat() bool {
@@ -189,21 +283,21 @@ func bytesAsFloat(bytes ...byte) float64 {
 
 }
 */
+//Float reads a FLOAT or an INDIRECT_FLOAT
 func (r Ref) Float() (float64, error) {
-	if !r.IsFloat() {
-		return 0, fmt.Errorf("flexbuffers object of type %s can not be converted to float", r.context.ItemVarType().toString())
+	if !r.IsFloat() && r.context.ItemVarType() != INDIRECT_FLOAT {
+		return 0, r.mismatch("object of type %s can not be converted to float", r.context.ItemVarType().toString())
 	}
 	abs_offset := r.index_0
 	switch r.context.ItemByteSize() {
 	case b32:
-		x := r.buffer[abs_offset : abs_offset+B(b32)]
-		u := binary.LittleEndian.Uint32(x)
-		return float64(math.Float32frombits(u)), nil
+		u, err := r.readUint(abs_offset, B(b32))
+		return float64(math.Float32frombits(uint32(u))), err
 	case b64:
-		u := binary.LittleEndian.Uint64(r.buffer[abs_offset : abs_offset+B(b64)])
-		return math.Float64frombits(u), nil
+		u, err := r.readUint(abs_offset, B(b64))
+		return math.Float64frombits(u), err
 	}
-	panic("an unexpected error has occured")
+	return 0, r.corrupt(abs_offset, "float of %d byte(s)", B(r.context.ItemByteSize()))
 }
 
 func (r Ref) IsNull() bool {
@@ -218,13 +312,41 @@ func (r Ref) IsKey() bool {
 	return r.context.ItemVarType() == KEY
 }
 
-func (r Ref) AsString() string {
-	vType := r.context.ItemVarType()
-	if vType == STRING || vType == KEY {
-		return string(r.buffer[r.index_0 : r.index_0+uint64(r.item_count)])
+func (r Ref) IsBlob() bool {
+	return r.context.ItemVarType() == BLOB
+}
+
+//String returns the contents of a STRING or KEY
+func (r Ref) String() (string, error) {
+	if !r.IsString() && !r.IsKey() {
+		return "", r.mismatch("object of type %s can not be converted to string", r.context.ItemVarType().toString())
 	}
+	bytes, err := r.getBytes(r.index_0, r.item_count)
+	return string(bytes), err
+}
+
+//Key returns the contents of a KEY
+func (r Ref) Key() (string, error) {
+	if !r.IsKey() {
+		return "", r.mismatch("object of type %s can not be converted to key", r.context.ItemVarType().toString())
+	}
+	bytes, err := r.getBytes(r.index_0, r.item_count)
+	return string(bytes), err
+}
+
+//Blob returns the contents of a BLOB. The slice is a view into the buffer, it is not copied
+func (r Ref) Blob() ([]byte, error) {
+	if !r.IsBlob() {
+		return nil, r.mismatch("object of type %s can not be converted to []byte", r.context.ItemVarType().toString())
+	}
+	bytes, err := r.getBytes(r.index_0, r.item_count)
+	return bytes[:len(bytes):len(bytes)], err
+}
+
+//AsString returns the contents of a STRING or KEY, like String
+func (r Ref) AsString() (string, error) {
 	//TODO: possibly add support for other types
-	panic(fmt.Sprintf("type %s can not be expressed as string", vType.toString()))
+	return r.String()
 }
 
 func (r Ref) IsUntypedVector() bool {
@@ -232,14 +354,23 @@ func (r Ref) IsUntypedVector() bool {
 }
 
 func (r Ref) UntypedVector() ([]interface{}, error) {
+	return r.untypedVector(0)
+}
+
+func (r Ref) untypedVector(depth int) ([]interface{}, error) {
 	if !r.IsUntypedVector() {
-		return nil, fmt.Errorf("flexbuffers object of type %s can not be converted to []interface{}", r.context.ItemVarType().toString())
+		return nil, r.mismatch("object of type %s can not be converted to []interface{}", r.context.ItemVarType().toString())
 	}
 	result := []interface{}{}
 	for i := int64(0); i < int64(r.item_count); i++ {
-		item_context := r.getItemContext(i)
-		item_ref := r.getItemAsRef(i, item_context)
-		x, _ := item_ref.Interface()
+		item_ref, err := r.Index(i)
+		if err != nil {
+			return nil, err
+		}
+		x, err := item_ref.unpack(depth + 1)
+		if err != nil {
+			return nil, atSegment(err, indexSegment(i))
+		}
 		result = append(result, x)
 	}
 	return result, nil
@@ -251,13 +382,19 @@ func (r Ref) IsIntTyped() bool {
 
 func (r Ref) IntSlice() ([]int64, error) {
 	if !r.IsIntTyped() {
-		return nil, fmt.Errorf("flexbuffers object of type %s can not be converted to []int64", r.context.ItemVarType().toString())
+		return nil, r.mismatch("object of type %s can not be converted to []int64", r.context.ItemVarType().toString())
 	}
 	bSize := r.context.ItemByteSize()
 	result := []int64{}
 	for i := int64(0); i < int64(r.item_count); i++ {
-		item_ref := r.getItemAsRef(i, Pack(INT, bSize))
-		x, _ := item_ref.Int() //HELP: can I just ignore unexpected errors like this one?
+		item_ref, err := r.getItemAsRef(i, Pack(INT, bSize))
+		if err != nil {
+			return nil, err
+		}
+		x, err := item_ref.Int()
+		if err != nil {
+			return nil, err
+		}
 		result = append(result, x)
 	}
 	return result, nil
@@ -269,13 +406,19 @@ func (r Ref) IsUintTyped() bool {
 
 func (r Ref) UintSlice() ([]uint64, error) {
 	if !r.IsUintTyped() {
-		return nil, fmt.Errorf("flexbuffers object of type %s can not be converted to []uint64", r.context.ItemVarType().toString())
+		return nil, r.mismatch("object of type %s can not be converted to []uint64", r.context.ItemVarType().toString())
 	}
 	bSize := r.context.ItemByteSize()
 	result := []uint64{}
 	for i := int64(0); i < int64(r.item_count); i++ {
-		item_ref := r.getItemAsRef(i, Pack(UINT, bSize))
-		x, _ := item_ref.Uint()
+		item_ref, err := r.getItemAsRef(i, Pack(UINT, bSize))
+		if err != nil {
+			return nil, err
+		}
+		x, err := item_ref.Uint()
+		if err != nil {
+			return nil, err
+		}
 		result = append(result, x)
 	}
 	return result, nil
@@ -287,12 +430,15 @@ func (r Ref) IsFloatTyped() bool {
 
 func (r Ref) FloatSlice() ([]float64, error) {
 	if !r.IsFloatTyped() {
-		return nil, fmt.Errorf("flexbuffers object of type %s can not be converted to []float64", r.context.ItemVarType().toString())
+		return nil, r.mismatch("object of type %s can not be converted to []float64", r.context.ItemVarType().toString())
 	}
 	bSize := r.context.ItemByteSize()
 	result := []float64{}
 	for i := int64(0); i < int64(r.item_count); i++ {
-		item_ref := r.getItemAsRef(i, Pack(FLOAT, bSize))
+		item_ref, err := r.getItemAsRef(i, Pack(FLOAT, bSize))
+		if err != nil {
+			return nil, err
+		}
 		x, err := item_ref.Float()
 		if err != nil {
 			return nil, err
@@ -308,17 +454,45 @@ func (r Ref) IsBoolTyped() bool {
 
 func (r Ref) BoolSlice() ([]bool, error) {
 	if !r.IsBoolTyped() {
-		return nil, fmt.Errorf("flexbuffers object of type %s can not be converted to []bool", r.context.ItemVarType().toString())
+		return nil, r.mismatch("object of type %s can not be converted to []bool", r.context.ItemVarType().toString())
 	}
 	result := []bool{}
 	for i := int64(0); i < int64(r.item_count); i++ {
-		item_ref := r.getItemAsRef(i, Pack(BOOL, b8))
-		x, _ := item_ref.Bool()
+		item_ref, err := r.getItemAsRef(i, Pack(BOOL, b8))
+		if err != nil {
+			return nil, err
+		}
+		x, err := item_ref.Bool()
+		if err != nil {
+			return nil, err
+		}
 		result = append(result, x)
 	}
 	return result, nil
 }
 
+func (r Ref) IsStringTyped() bool {
+	vType := r.context.ItemVarType()
+	return vType == VECTOR_KEY || vType == VECTOR_STRING_DEPRECATED
+}
+
+func (r Ref) StringSlice() ([]string, error) {
+	if !r.IsStringTyped() {
+		return nil, r.mismatch("object of type %s can not be converted to []string", r.context.ItemVarType().toString())
+	}
+	result := make([]string, r.item_count)
+	for i := range result {
+		item_ref, err := r.Index(int64(i))
+		if err != nil {
+			return nil, err
+		}
+		if result[i], err = item_ref.String(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
 func (r Ref) IsTyped() bool {
 	return isTyped(r.context.ItemVarType())
 }
@@ -346,272 +520,278 @@ func (r Ref) IsIterable() bool {
 func (r Ref) Index(i int64) (Ref, error) {
 	vType := r.context.ItemVarType()
 	if !r.IsIterable() {
-		return Ref{}, fmt.Errorf("flexbuffers object of type %s does not support indexing", vType.toString())
+		return Ref{}, r.mismatch("object of type %s does not support indexing", vType.toString())
 	}
 	if !r.InsideBounds(i) {
-		return Ref{}, fmt.Errorf("out of bounds")
+		return Ref{}, r.outOfBounds(i)
 	}
-	rel_offset := r.relItemOffset(i)
-	abs_offset := r.absOffset(rel_offset)
 	var context context
+	var err error
 	if r.IsTyped() {
-		switch true {
-		case isUintTyped(vType):
-			context = Pack(UINT, r.context.ItemByteSize())
-		case isIntTyped(vType):
-			context = Pack(INT, r.context.ItemByteSize())
-		case isFloatTyped(vType):
-			context = Pack(FLOAT, r.context.ItemByteSize())
-		case isBoolTyped(vType):
-			context = Pack(BOOL, r.context.ItemByteSize())
-		case vType == VECTOR_KEY:
-			context = Pack(KEY, b8)
-		default:
-			panic("an unknown error has occured")
-		}
-
+		context, err = r.typedItemContext()
 	} else {
 		//untyped iterable
-		context = r.getItemContext(i)
+		context, err = r.getItemContext(i)
+	}
+	if err != nil {
+		return Ref{}, err
 	}
-	return r.getItemAsRef(int64(abs_offset), context), nil //HELP: double checking IsIterable,InsideBounds
+	return r.getItemAsRef(i, context) //HELP: double checking IsIterable,InsideBounds
+}
+
+//all items of a typed vector share the same context
+func (r Ref) typedItemContext() (context, error) {
+	vType := r.context.ItemVarType()
+	switch true {
+	case isUintTyped(vType):
+		return Pack(UINT, r.context.ItemByteSize()), nil
+	case isIntTyped(vType):
+		return Pack(INT, r.context.ItemByteSize()), nil
+	case isFloatTyped(vType):
+		return Pack(FLOAT, r.context.ItemByteSize()), nil
+	case isBoolTyped(vType):
+		return Pack(BOOL, r.context.ItemByteSize()), nil
+	case vType == VECTOR_KEY:
+		return Pack(KEY, b8), nil
+	case vType == VECTOR_STRING_DEPRECATED:
+		return Pack(STRING, r.context.ItemByteSize()), nil
+	}
+	return 0, r.corrupt(r.index_0, "unexpected typed vector of type %s", vType.toString())
 }
 
 func (r Ref) IsMap() bool {
 	return r.context.ItemVarType() == MAP
 }
 
+//MapIndex returns the value of the given key. Keys are found with a binary search on the key vector of the map,
+//without allocating
 func (r Ref) MapIndex(key string) (Ref, error) {
 	if !r.IsMap() {
-		return Ref{}, fmt.Errorf("flexbuffers object of type %s does not support key mapping", r.context.ItemVarType().toString())
+		return Ref{}, r.mismatch("object of type %s does not support key mapping", r.context.ItemVarType().toString())
 	}
-	key_vector_ref := r.KeyVector()
-	var binary_search_keys func(string, Ref, int, int) int
-	binary_search_keys = func(key string, kv_ref Ref, lower_bounds int, upper_bounds int) int {
-		if lower_bounds >= upper_bounds {
-			return -1 //nothing found
-		}
-		pivot := (upper_bounds - lower_bounds) / 2
-		key_ref := kv_ref.getItemAsRef(int64(pivot), Pack(KEY, b8))
-		key_bytes := key_ref.getBytes(key_ref.index_0, key_ref.item_count)
-		c := bytes.Compare(key_bytes, *(*[]byte)(unsafe.Pointer(&key)))
-		if c == 0 {
-			return pivot
-		}
-		if c < 0 {
-			return binary_search_keys(key, kv_ref, lower_bounds, pivot)
-		}
-		return binary_search_keys(key, kv_ref, pivot+1, upper_bounds)
+	item_index, err := r.findKey(*(*[]byte)(unsafe.Pointer(&key)))
+	if err != nil {
+		return Ref{}, err
 	}
-	item_index := binary_search_keys(key, key_vector_ref, 0, int(key_vector_ref.item_count))
 	if item_index < 0 { //not found
-		return Ref{}, fmt.Errorf("key not found in map")
+		return Ref{}, r.keyNotFound(key)
 	}
-	return r.Index(int64(item_index))
+	return r.Index(item_index)
 }
 
-func (r Ref) KeyVector() Ref {
-	if !r.IsMap() {
-		panic(fmt.Sprintf("flexbuffers object of type %s does not support key mapping", r.context.ItemVarType().toString()))
+//returns the index of key in the map, or -1 if the map does not contain it
+func (r Ref) findKey(key []byte) (int64, error) {
+	kv, err := r.KeyVector()
+	if err != nil {
+		return -1, err
 	}
-	key_vector_bWidth := bytesAsUint(r.getBytes(r.index_0-2, 1)...)
-	key_vector_offset := bytesAsUint(r.getBytes(r.index_0-3, 1)...)
-	key_vector_index_0 := r.index_0 - key_vector_offset
-	key_vector_context := Pack(VECTOR_KEY, b(int(key_vector_bWidth)))
-	key_vector_ref := Ref{buffer: r.buffer, index_0: key_vector_index_0, context: key_vector_context}
-	key_vector_ref.item_count = key_vector_ref.itemCount()
-	return key_vector_ref
+	return kv.searchKeys(0, int64(kv.item_count), key)
 }
 
-func (r Ref) Map() (map[string]interface{}, error) {
-	if !r.IsMap() {
-		return nil, fmt.Errorf("flexbuffers object of type %s does not support key mapping", r.context.ItemVarType().toString())
-	}
-	key_vector_ref := r.KeyVector()
-	kv_bSize := b(int(key_vector_ref.context.ItemByteSize()))
-	m := map[string]interface{}{}
-	for i := int64(0); i < int64(r.item_count); i++ {
-		key_ref := key_vector_ref.getItemAsRef(i, Pack(KEY, kv_bSize))
-		k := key_ref.AsString()
-		val_ref, err := r.MapIndex(k)
+//returns the index of key among the keys [lower, upper) of a key vector, or -1 if they do not contain it. Keys are
+//sorted bytewise
+func (kv Ref) searchKeys(lower int64, upper int64, key []byte) (int64, error) {
+	for lower < upper {
+		pivot := lower + (upper-lower)/2
+		c, err := kv.compareKeyAt(pivot, key)
 		if err != nil {
-			return nil, err
+			return -1, err
+		}
+		switch true {
+		case c == 0:
+			return pivot, nil
+		case c < 0:
+			lower = pivot + 1
+		default:
+			upper = pivot
 		}
-		v, err := val_ref.Interface()
+	}
+	return -1, nil
+}
+
+//returns the index of the first key among the keys [lower, upper) of a key vector that is not less than key, or upper
+//if there is none
+func (kv Ref) lowerBoundKey(lower int64, upper int64, key []byte) (int64, error) {
+	for lower < upper {
+		pivot := lower + (upper-lower)/2
+		c, err := kv.compareKeyAt(pivot, key)
 		if err != nil {
-			return nil, err
+			return -1, err
+		}
+		if c < 0 {
+			lower = pivot + 1
+		} else {
+			upper = pivot
 		}
-		m[k] = v
 	}
-	return m, nil
+	return lower, nil
 }
 
-func (r Ref) Interface() (interface{}, error) {
-	if res, err := r.Int(); err == nil {
-		return res, nil
+//compares the key at the given index of a key vector with key, like bytes.Compare. The key in the buffer is read
+//only as far as needed
+func (kv Ref) compareKeyAt(i int64, key []byte) (int, error) {
+	abs_offset := kv.absItemOffset(i)
+	off, err := kv.readUint(abs_offset, B(kv.context.ItemByteSize()))
+	if err != nil {
+		return 0, err
 	}
-	if res, err := r.Uint(); err == nil {
-		return res, nil
+	if off > abs_offset {
+		return 0, kv.corrupt(abs_offset, "offset %d points before the start of the buffer", off)
 	}
-	if res, err := r.Float(); err == nil {
-		return res, nil
+	stored := kv.buffer[abs_offset-off:]
+	for j, c := range key {
+		if j == len(stored) {
+			return 0, kv.corrupt(abs_offset-off, "key is not 0-terminated")
+		}
+		switch true {
+		case stored[j] == c:
+			continue
+		case stored[j] < c: //includes the end of the stored key
+			return -1, nil
+		}
+		return 1, nil
 	}
-	if res, err := r.Bool(); err == nil {
-		return res, nil
+	if len(key) == len(stored) {
+		return 0, kv.corrupt(abs_offset-off, "key is not 0-terminated")
 	}
-	if r.IsNull() {
-		return nil, nil
+	if stored[len(key)] == 0 {
+		return 0, nil
 	}
-	if res, err := r.IntSlice(); err == nil {
-		return res, nil
+	return 1, nil
+}
+
+func (r Ref) KeyVector() (Ref, error) {
+	if !r.IsMap() {
+		return Ref{}, r.mismatch("object of type %s does not support key mapping", r.context.ItemVarType().toString())
 	}
-	if res, err := r.UintSlice(); err == nil {
-		return res, nil
+	//a map is prefixed with an offset to its key vector and the key vector byte width, both stored with the map's width
+	bWidth := B(r.context.ItemByteSize())
+	if r.index_0 < 3*bWidth {
+		return Ref{}, r.corrupt(r.index_0, "map prefix out of bounds")
 	}
-	if res, err := r.FloatSlice(); err == nil {
-		return res, nil
+	key_vector_bWidth, err := r.readUint(r.index_0-2*bWidth, bWidth)
+	if err != nil {
+		return Ref{}, err
 	}
-	if res, err := r.BoolSlice(); err == nil {
-		return res, nil
+	if !isValidWidth(key_vector_bWidth) {
+		return Ref{}, r.corrupt(r.index_0-2*bWidth, "invalid key vector byte width %d", key_vector_bWidth)
 	}
-	if res, err := r.UntypedVector(); err == nil {
-		return res, nil
+	key_vector_offset, err := r.readUint(r.index_0-3*bWidth, bWidth)
+	if err != nil {
+		return Ref{}, err
 	}
-	if res, err := r.Map(); err == nil {
-		return res, nil
+	if key_vector_offset > r.index_0-3*bWidth {
+		return Ref{}, r.corrupt(r.index_0-3*bWidth, "offset %d points before the start of the buffer", key_vector_offset)
 	}
-	return nil, fmt.Errorf("unexpected error - flexbuffer is corrupted. Unable to deserialize object of type %s", r.context.ItemVarType().toString())
-
-}
-
-//HELP: I question the need for this
-type scanner struct {
-	Ref
-	index int64
-}
-
-func (s scanner) Next() bool {
-	return s.InsideBounds(s.index)
-}
-
-func (s *scanner) Value() Ref {
-	if !s.Next() {
-		panic("scanner out of bounds")
+	key_vector_index_0 := r.index_0 - 3*bWidth - key_vector_offset
+	key_vector_context := Pack(VECTOR_KEY, b(int(key_vector_bWidth)))
+	key_vector_ref := Ref{buffer: r.buffer, index_0: key_vector_index_0, context: key_vector_context}
+	if key_vector_ref.item_count, err = key_vector_ref.itemCount(); err != nil {
+		return Ref{}, err
 	}
-	r := s.getItemAsRef(s.index, s.context)
-	s.index++
-	return r
+	if key_vector_ref.item_count != r.item_count {
+		return Ref{}, r.corrupt(key_vector_index_0, "map of %d value(s) has %d key(s)", r.item_count, key_vector_ref.item_count)
+	}
+	return key_vector_ref, nil
 }
 
-type VecScanner struct {
-	scanner
+//returns the key at the given index of a key vector
+func (kv Ref) keyAt(i int64) (string, error) {
+	key_bytes, err := kv.keyBytesAt(i)
+	return string(key_bytes), err
 }
 
-func (r Ref) VecScan() (VecScanner, bool) {
-	if !r.IsVector() {
-		return VecScanner{}, false //HELP: shouldn't this be an error?
+//returns the key at the given index of a key vector, as a view into the buffer
+func (kv Ref) keyBytesAt(i int64) ([]byte, error) {
+	key_ref, err := kv.getItemAsRef(i, Pack(KEY, b8))
+	if err != nil {
+		return nil, err
 	}
-	return VecScanner{scanner{r, 0}}, true
+	return key_ref.getBytes(key_ref.index_0, key_ref.item_count)
 }
 
-func (vs VecScanner) Index() int64 { //HELP: what's the reason this method should be exclusive to VecScanner?
-	return vs.index
-}
-
-type MapScanner struct {
-	scanner
-	keyVec Ref
+func (r Ref) Map() (map[string]interface{}, error) {
+	return r.toMap(0)
 }
 
-func (r Ref) MapScan() (MapScanner, bool) {
+func (r Ref) toMap(depth int) (map[string]interface{}, error) {
 	if !r.IsMap() {
-		return MapScanner{}, false //HELP: shouldn't this be an error?
+		return nil, r.mismatch("object of type %s does not support key mapping", r.context.ItemVarType().toString())
 	}
-	kv := r.KeyVector()
-	return MapScanner{scanner{r, 0}, kv}, true
-}
-
-func (ms MapScanner) Key() string {
-	key, err := ms.keyVec.Index(ms.index)
+	key_vector_ref, err := r.KeyVector()
 	if err != nil {
-		panic(err) //should never happen
+		return nil, err
 	}
-	return key.AsString()
-}
-
-/*
-func (Ref) IsInt() bool
-func (Ref) Int() (int, bool)
-func (Ref) IsUint() bool
-func (Ref) Uint() (int, bool)
-func (Ref) IsT() bool    // {Float,Bool,String,Blob,Vector,Map,Null}
-func (Ref) T() (T, bool) // {Float,Bool,String,Blob,Vector,Map}
-
-// NB: in TS, these two are combined in a single get(key: number) function (that has a type violation?)
-func (Ref) Index(i int) (Ref, bool)
-func (Ref) MapIndex(k string) (Ref, bool)
-
-func (Ref) Interface() (interface{}, error) // unpacks to a native {*int*,float*,bool,string,map[string]*,[]*,nil}
-
-func (Ref) Len() int // XXX: unclear semantics (e.g. 1 if a map?)
-
-func (Ref) Scan() (Scanner, bool)
-func (Ref) MapScan() (MapScanner, bool)
-
-type Scanner struct {
-	buf []byte
-}
-
-func (Scanner) Next() bool
-func (Scanner) Index() int // only if vec!
-func (Scanner) Value() Ref
-
-type MapScanner struct {
-	buf []byte
+	m := map[string]interface{}{}
+	for i := int64(0); i < int64(r.item_count); i++ {
+		k, err := key_vector_ref.keyAt(i)
+		if err != nil {
+			return nil, err
+		}
+		val_ref, err := r.Index(i)
+		if err != nil {
+			return nil, err
+		}
+		v, err := val_ref.unpack(depth + 1)
+		if err != nil {
+			return nil, atSegment(err, keySegment(k))
+		}
+		m[k] = v
+	}
+	return m, nil
 }
 
-func (MapScanner) Key() string // only if map!
-func (MapScanner) Next() bool
-func (MapScanner) Value() Ref
-
-root := Reference(buf)
-scanner, _ := root.Scan()
-
-for scanner.Scan() {
-	v := scanner.Value()
-	i := scanner.Index()
+//Interface unpacks the object to a native Go value: nil, int64, uint64, float64, bool, string (STRING and KEY),
+//[]byte (BLOB, a view into the buffer), []int64, []uint64, []float64, []bool, []string (typed and fixed typed vectors),
+//[]interface{} (VECTOR) or map[string]interface{} (MAP). Indirect scalars are unpacked like inline ones
+func (r Ref) Interface() (interface{}, error) {
+	return r.unpack(0)
 }
 
+//Corrupted buffers may contain offsets that form a cycle, so recursive decoding stops at this depth
+const maxNestingDepth = 10000
 
-
-
-r := Reference(buff)
-arr :=[]int{}
-for i:= 0;i<r.Len();i++ {
-	ri,ok:=r.Index(i)
-	if !ok {
-		return error
-	}
-	n, err := ri.Float()
-	if err != nil {
-		return err
+func (r Ref) checkDepth(depth int) error {
+	if depth > maxNestingDepth {
+		return r.corrupt(r.index_0, "structures nested deeper than %d levels", maxNestingDepth)
 	}
-	arr = append(arr,n)
+	return nil
 }
 
-func Unpack(buf []byte) interface{} {}
-v,ok:= VectorInt(Unpack(buff))
-if !ok {
-	return error
-}
-arr :=[]int{}
-for i:=0;i<v.itemCount();i++ {
-	arr = append(arr,v.getItem(i))
+func (r Ref) unpack(depth int) (interface{}, error) {
+	if err := r.checkDepth(depth); err != nil {
+		return nil, err
+	}
+	vType := r.context.ItemVarType()
+	switch true {
+	case vType == NULL:
+		return nil, nil
+	case vType == INT, vType == INDIRECT_INT:
+		return r.Int()
+	case vType == UINT, vType == INDIRECT_UINT:
+		return r.Uint()
+	case vType == FLOAT, vType == INDIRECT_FLOAT:
+		return r.Float()
+	case vType == BOOL:
+		return r.Bool()
+	case vType == STRING, vType == KEY:
+		return r.String()
+	case vType == BLOB:
+		return r.Blob()
+	case r.IsIntTyped():
+		return r.IntSlice()
+	case r.IsUintTyped():
+		return r.UintSlice()
+	case r.IsFloatTyped():
+		return r.FloatSlice()
+	case r.IsBoolTyped():
+		return r.BoolSlice()
+	case r.IsStringTyped():
+		return r.StringSlice()
+	case vType == VECTOR:
+		return r.untypedVector(depth)
+	case vType == MAP:
+		return r.toMap(depth)
+	}
+	return nil, r.corrupt(r.index_0, "unable to deserialize object of type %s", vType.toString())
 }
-
-
-var buf []byte
-err := quick.Walk(buf, func(root quick.Ref) {
-	n := root.Index(3).Int()
-})
-*/
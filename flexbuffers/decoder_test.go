@@ -0,0 +1,1021 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	buff, err := Marshal(v)
+	require.NoError(t, err, "marshal has failed")
+	return buff
+}
+
+//serializes what build adds to a Builder with the given options
+func mustBuild(t *testing.T, opts BuilderOptions, build func(b *Builder) error) []byte {
+	b := NewBuilderWithOptions(opts)
+	require.NoError(t, build(b), "build has failed")
+	buff, err := b.Bytes()
+	require.NoError(t, err, "serialization has failed")
+	return buff
+}
+
+//like mustMarshal, with the given options
+func mustMarshalWithOptions(t *testing.T, opts BuilderOptions, v interface{}) []byte {
+	return mustBuild(t, opts, func(b *Builder) error { return b.AutoBuild(v) })
+}
+
+func TestUnmarshalScalars(t *testing.T) {
+	var i int
+	require.NoError(t, Unmarshal(mustMarshal(t, -42), &i))
+	require.Equal(t, -42, i)
+
+	var u uint16
+	require.NoError(t, Unmarshal(mustMarshal(t, 300), &u))
+	require.Equal(t, uint16(300), u)
+
+	var f float32
+	require.NoError(t, Unmarshal(mustMarshal(t, 2.5), &f))
+	require.Equal(t, float32(2.5), f)
+
+	var l bool
+	require.NoError(t, Unmarshal(mustMarshal(t, true), &l))
+	require.True(t, l)
+
+	var s string
+	require.NoError(t, Unmarshal(mustMarshal(t, "alpha"), &s))
+	require.Equal(t, "alpha", s)
+
+	var p *int
+	require.NoError(t, Unmarshal(mustMarshal(t, 7), &p))
+	require.Equal(t, 7, *p)
+
+	//indirect scalars decode like inline ones, also into struct fields
+	indirect := func(start func(b *Builder) error, add func(b *Builder) error) []byte {
+		b := NewBuilder()
+		require.NoError(t, b.StartMap())
+		require.NoError(t, start(b))
+		require.NoError(t, add(b))
+		b.End()
+		b.End()
+		buff, err := b.Bytes()
+		require.NoError(t, err)
+		return buff
+	}
+	var ints struct {
+		X int `flexbuffers:"x"`
+	}
+	var floats struct {
+		F float64 `flexbuffers:"x"`
+	}
+	buff := indirect(func(b *Builder) error { return b.StartIntScalarWithKey("x") }, func(b *Builder) error { return b.Int(-300) })
+	require.NoError(t, Unmarshal(buff, &ints))
+	require.Equal(t, -300, ints.X)
+	require.NoError(t, Unmarshal(buff, &floats))
+	require.Equal(t, -300.0, floats.F)
+	var uints struct {
+		U uint8 `flexbuffers:"x"`
+	}
+	buff = indirect(func(b *Builder) error { return b.StartUintScalarWithKey("x") }, func(b *Builder) error { return b.Uint(200) })
+	require.NoError(t, Unmarshal(buff, &uints))
+	require.Equal(t, uint8(200), uints.U)
+	buff = indirect(func(b *Builder) error { return b.StartFloatScalarWithKey("x") }, func(b *Builder) error { return b.Float(0.1) })
+	require.NoError(t, Unmarshal(buff, &floats))
+	require.Equal(t, 0.1, floats.F)
+	var typeErr *UnmarshalTypeError
+	require.ErrorAs(t, Unmarshal(buff, &ints), &typeErr)
+	require.Equal(t, VarType(INDIRECT_FLOAT), typeErr.Value)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var i int8
+	err := Unmarshal(mustMarshal(t, 300), &i)
+	require.ErrorIs(t, err, ErrOverflow)
+
+	var u uint
+	require.ErrorIs(t, Unmarshal(mustMarshal(t, -1), &u), ErrOverflow)
+
+	var i64 int64
+	require.ErrorIs(t, Unmarshal(mustMarshal(t, uint64(math.MaxUint64)), &i64), ErrOverflow)
+
+	var f32 float32
+	require.ErrorIs(t, Unmarshal(mustMarshal(t, 1e300), &f32), ErrOverflow)
+
+	var fields struct {
+		Counts []uint8 `flexbuffers:"counts"`
+	}
+	err = Unmarshal(mustMarshal(t, map[string][]int{"counts": {1, 256}}), &fields)
+	require.ErrorIs(t, err, ErrOverflow)
+	var refErr *RefError
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, "$.counts[1]", refErr.Path())
+
+	var s string
+	err = Unmarshal(mustMarshal(t, 1), &s)
+	var typeErr *UnmarshalTypeError
+	require.ErrorAs(t, err, &typeErr)
+	require.Equal(t, VarType(INT), typeErr.Value)
+
+	require.Error(t, Unmarshal(mustMarshal(t, 1), i))
+	require.Error(t, Unmarshal([]byte{}, &i))
+}
+
+type unmarshalInner struct {
+	Name string `flexbuffers:"name"`
+	Tags []string
+}
+
+type unmarshalOuter struct {
+	ID      int64             `flexbuffers:"id"`
+	Ignored int               `flexbuffers:"-"`
+	Score   float64           `flexbuffers:"score,omitempty"`
+	Inner   unmarshalInner    `flexbuffers:"inner"`
+	Ptr     *unmarshalInner   `flexbuffers:"ptr"`
+	Counts  map[string]uint32 `flexbuffers:"counts"`
+	Triple  [3]int16          `flexbuffers:"triple"`
+	Any     interface{}       `flexbuffers:"any"`
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	buff := mustMarshal(t, map[string]interface{}{
+		"id":      12,
+		"Ignored": 5,
+		"inner":   map[string]interface{}{"name": "in", "tags": []interface{}{"a", "b"}},
+		"ptr":     map[string]interface{}{"name": "p"},
+		"counts":  map[string]interface{}{"x": 1, "y": 2},
+		"triple":  []int{1, 2, 3},
+		"any":     []interface{}{1, "two", map[string]interface{}{"three": 3}},
+		"unknown": true,
+	})
+	require.Less(t, len(buff), 256)
+	var v unmarshalOuter
+	require.NoError(t, Unmarshal(buff, &v))
+	require.Equal(t, unmarshalOuter{
+		ID:     12,
+		Inner:  unmarshalInner{Name: "in", Tags: []string{"a", "b"}},
+		Ptr:    &unmarshalInner{Name: "p"},
+		Counts: map[string]uint32{"x": 1, "y": 2},
+		Triple: [3]int16{1, 2, 3},
+		Any:    []interface{}{int64(1), "two", map[string]interface{}{"three": int64(3)}},
+	}, v)
+
+	var scored unmarshalOuter
+	require.NoError(t, Unmarshal(mustMarshal(t, map[string]interface{}{"id": 1, "score": 0.5}), &scored))
+	require.Equal(t, unmarshalOuter{ID: 1, Score: 0.5}, scored)
+
+	buff = mustMarshal(t, map[string]interface{}{"inner": map[string]interface{}{"name": 1}})
+	err := Unmarshal(buff, &v)
+	var typeErr *UnmarshalTypeError
+	require.ErrorAs(t, err, &typeErr)
+	require.Equal(t, "unmarshalOuter.inner.name", typeErr.Field)
+
+	//a type embedding itself is flattened once
+	var self selfEmbedding
+	require.NoError(t, Unmarshal(mustMarshal(t, map[string]interface{}{"X": 1, "selfEmbedding": 2}), &self))
+	require.Equal(t, selfEmbedding{X: 1}, self)
+}
+
+type selfEmbedding struct {
+	*selfEmbedding
+	X int
+}
+
+func TestUnmarshalSlices(t *testing.T) {
+	var ints []int32
+	require.NoError(t, Unmarshal(mustMarshal(t, []int{1, -2, 300}), &ints))
+	require.Equal(t, []int32{1, -2, 300}, ints)
+
+	var floats []float64
+	require.NoError(t, Unmarshal(mustMarshal(t, []float64{1.5, 2}), &floats))
+	require.Equal(t, []float64{1.5, 2}, floats)
+
+	var nested [][]interface{}
+	require.NoError(t, Unmarshal(mustMarshal(t, []interface{}{[]interface{}{1, "a"}, []interface{}{}}), &nested))
+	require.Equal(t, [][]interface{}{{int64(1), "a"}, {}}, nested)
+
+	var arr [2]uint8
+	require.NoError(t, Unmarshal(mustMarshal(t, []uint{4, 5, 6}), &arr))
+	require.Equal(t, [2]uint8{4, 5}, arr)
+
+	var m map[string]interface{}
+	require.Error(t, Unmarshal(mustMarshal(t, []int{1}), &m))
+}
+
+func TestRefInterface(t *testing.T) {
+	b := NewBuilder()
+	require.NoError(t, b.StartVector())
+	require.NoError(t, b.String("str"))
+	require.NoError(t, b.Key("key"))
+	require.NoError(t, b.StartBlob([]byte{1, 2, 3}))
+	b.End()
+	require.NoError(t, b.StartIntScalar())
+	require.NoError(t, b.Int(-300))
+	b.End()
+	require.NoError(t, b.StartFloatScalar())
+	require.NoError(t, b.Float(1.5))
+	b.End()
+	require.NoError(t, b.StartUintTriple())
+	require.NoError(t, b.Uint(1))
+	require.NoError(t, b.Uint(2))
+	require.NoError(t, b.Uint(3))
+	b.End()
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.StringWithKey("s", "v"))
+	b.End()
+	b.End()
+	var buff []byte
+	_, err := b.SerializeBuffer(&buff)
+	require.NoError(t, err)
+
+	x, err := NewRef(buff).Interface()
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		"str", "key", []byte{1, 2, 3}, int64(-300), 1.5, []uint64{1, 2, 3}, map[string]interface{}{"s": "v"},
+	}, x)
+
+	root := NewRef(buff)
+	item, err := root.Index(0)
+	require.NoError(t, err)
+	s, err := item.String()
+	require.NoError(t, err)
+	require.Equal(t, "str", s)
+	_, err = item.Key()
+	require.Error(t, err)
+	_, err = item.Blob()
+	require.Error(t, err)
+
+	item, err = root.Index(1)
+	require.NoError(t, err)
+	k, err := item.Key()
+	require.NoError(t, err)
+	require.Equal(t, "key", k)
+
+	//blobs are not copied
+	item, err = root.Index(2)
+	require.NoError(t, err)
+	blob, err := item.Blob()
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, blob)
+	blob[0] = 9
+	item, _ = root.Index(2)
+	blob, _ = item.Blob()
+	require.Equal(t, byte(9), blob[0])
+	require.Equal(t, 3, cap(blob))
+
+	item, err = root.Index(3)
+	require.NoError(t, err)
+	i, err := item.Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(-300), i)
+	_, err = item.String()
+	require.Error(t, err)
+
+	x, err = NewRef(mustMarshal(t, [2]float64{0.5, 2})).Interface()
+	require.NoError(t, err)
+	require.Equal(t, []float64{0.5, 2}, x)
+}
+
+func TestRefErrors(t *testing.T) {
+	buff := mustMarshal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "ann"},
+			map[string]interface{}{"name": 5},
+		},
+	})
+	root, err := Root(buff)
+	require.NoError(t, err)
+	users, err := root.MapIndex("users")
+	require.NoError(t, err)
+	user, err := users.Index(1)
+	require.NoError(t, err)
+	name, err := user.MapIndex("name")
+	require.NoError(t, err)
+
+	//paths start at the Ref the failed call has been made on
+	_, err = name.String()
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	var refErr *RefError
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, "$", refErr.Path())
+	require.Equal(t, name.index_0, refErr.Offset)
+	_, err = Lookup[string](root, "users", "1", "name")
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, "$.users[1].name", refErr.Path())
+	require.Equal(t, name.index_0, refErr.Offset)
+	require.Contains(t, err.Error(), "$.users[1].name")
+	_, err = SliceOf[string](users)
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, "$[0]", refErr.Path())
+
+	_, err = users.Index(5)
+	require.ErrorIs(t, err, ErrOutOfBounds)
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, "$[5]", refErr.Path())
+	_, err = users.Index(-1)
+	require.ErrorIs(t, err, ErrOutOfBounds)
+
+	_, err = user.MapIndex("e-mail")
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, `$["e-mail"]`, refErr.Path())
+	_, err = root.Lookup("/users/1/e-mail")
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, `$.users[1]["e-mail"]`, refErr.Path())
+
+	//values shared by several parents are reported under the one they have been reached from
+	b := NewBuilderWithOptions(BuilderOptions{ShareStrings: true})
+	require.NoError(t, b.AutoBuild(map[string]string{"a": "x", "b": "x"}))
+	shared, err := b.Bytes()
+	require.NoError(t, err)
+	_, err = Lookup[int](*NewRef(shared), "b")
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, "$.b", refErr.Path())
+
+	_, err = name.Index(0)
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	_, err = name.KeyVector()
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	_, err = name.Scan()
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	scanner, err := users.Scan()
+	require.NoError(t, err)
+	require.False(t, scanner.SeekKey("name"))
+	require.ErrorIs(t, scanner.Err(), ErrTypeMismatch)
+
+	//structural errors are reported instead of panicking
+	_, err = Root(buff[:2])
+	require.ErrorIs(t, err, ErrCorrupt)
+	require.Panics(t, func() { NewRef(nil) })
+	require.ErrorIs(t, Unmarshal(append([]byte{}, buff[len(buff)-3:]...), &struct{}{}), ErrCorrupt)
+	require.ErrorIs(t, Verify(buff[:len(buff)-1], VerifyOptions{}), ErrCorrupt)
+
+	cycle := []byte{1, 0, byte(Pack(VECTOR, b8)), 2, byte(Pack(VECTOR, b8)), 1}
+	_, err = NewRef(cycle).Interface()
+	require.ErrorIs(t, err, ErrCorrupt)
+	var out interface{}
+	require.ErrorIs(t, Unmarshal(cycle, &out), ErrCorrupt)
+
+	//no buffer makes the Ref API panic, whether or not it passes Verify
+	for i := range buff {
+		for _, x := range []byte{0, 1, 3, 0x24, 0x7f, 0xff, buff[i] + 1, buff[i] - 1} {
+			c := append([]byte{}, buff...)
+			c[i] = x
+			require.NotPanics(t, func() {
+				r, err := Root(c)
+				if err != nil {
+					_ = err.Error()
+					return
+				}
+				if _, err := r.Interface(); err != nil {
+					_ = err.Error()
+				}
+				_ = Unmarshal(c, &out)
+				_ = r.WriteJSON(&bytes.Buffer{})
+				if users, err := r.MapIndex("users"); err == nil {
+					_, _ = users.Index(1)
+				}
+			}, "buffer %v", c)
+		}
+	}
+}
+
+func TestMutate(t *testing.T) {
+	b := NewBuilder()
+	require.NoError(t, b.StartVector())
+	require.NoError(t, b.Int(-1))
+	require.NoError(t, b.Uint(300))
+	require.NoError(t, b.Bool(false))
+	require.NoError(t, b.String("abc"))
+	require.NoError(t, b.StartBlob([]byte{1, 2}))
+	b.End()
+	require.NoError(t, b.StartIntScalar())
+	require.NoError(t, b.Int(5))
+	b.End()
+	b.End()
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	r := NewRef(buff)
+	item := func(i int64) Ref {
+		item_ref, err := r.Index(i)
+		require.NoError(t, err)
+		return item_ref
+	}
+
+	//inline items have the width of the vector, 2 bytes here
+	require.True(t, item(0).MutateInt(-32768))
+	require.False(t, item(0).MutateInt(32768))
+	require.True(t, item(1).MutateUint(65535))
+	require.False(t, item(1).MutateUint(65536))
+	require.False(t, item(1).MutateInt(-1))
+	require.True(t, item(0).MutateUint(7))
+	require.True(t, item(2).MutateBool(true))
+	require.False(t, item(2).MutateInt(1))
+	require.True(t, item(3).MutateString("xyz"))
+	require.False(t, item(3).MutateString("ab"))
+	require.True(t, item(4).MutateBlob([]byte{3, 4}))
+	require.False(t, item(4).MutateBlob([]byte{3}))
+	require.False(t, item(4).MutateString("ab"))
+	//indirect scalars have their own width, 1 byte here
+	require.True(t, item(5).MutateInt(127))
+	require.False(t, item(5).MutateInt(128))
+
+	var out []interface{}
+	require.NoError(t, Unmarshal(buff, &out))
+	require.Equal(t, []interface{}{int64(7), uint64(65535), true, "xyz", []byte{3, 4}, int64(127)}, out)
+
+	//shared strings and blobs are stored once, mutating one occurrence changes all of them
+	shared := mustBuild(t, BuilderOptions{ShareStructures: true}, func(b *Builder) error {
+		require.NoError(t, b.StartVector())
+		for i := 0; i < 2; i++ {
+			require.NoError(t, b.String("ab"))
+			require.NoError(t, b.StartBlob([]byte{1}))
+			b.End()
+		}
+		b.End()
+		return nil
+	})
+	r = NewRef(shared)
+	require.True(t, item(0).MutateString("cd"))
+	require.True(t, item(1).MutateBlob([]byte{2}))
+	require.NoError(t, Unmarshal(shared, &out))
+	require.Equal(t, []interface{}{"cd", []byte{2}, "cd", []byte{2}}, out)
+
+	floats := mustMarshal(t, []float32{1.5})
+	f, err := NewRef(floats).Index(0)
+	require.NoError(t, err)
+	require.True(t, f.MutateFloat(-0.25))
+	require.False(t, f.MutateFloat(0.1))
+	require.True(t, f.MutateFloat(math.NaN()))
+	require.True(t, f.MutateFloat(-0.25))
+	doubles := mustMarshal(t, []float64{0.3})
+	d, err := NewRef(doubles).Index(0)
+	require.NoError(t, err)
+	require.True(t, d.MutateFloat(0.1))
+	require.False(t, d.MutateInt(1))
+	var fs []float32
+	require.NoError(t, Unmarshal(floats, &fs))
+	require.Equal(t, []float32{-0.25}, fs)
+	var ds []float64
+	require.NoError(t, Unmarshal(doubles, &ds))
+	require.Equal(t, []float64{0.1}, ds)
+}
+
+func TestViews(t *testing.T) {
+	b := NewBuilder()
+	require.NoError(t, b.StartVector())
+	require.NoError(t, b.Int(-3))
+	require.NoError(t, b.Uint(300))
+	require.NoError(t, b.Float(2.5))
+	require.NoError(t, b.Bool(true))
+	require.NoError(t, b.String("str"))
+	require.NoError(t, b.Key("key"))
+	require.NoError(t, b.StartIntScalar())
+	require.NoError(t, b.Int(-70000))
+	b.End()
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.IntWithKey("c", 3))
+	require.NoError(t, b.IntWithKey("a", 1))
+	require.NoError(t, b.StringWithKey("b", "two"))
+	b.End()
+	b.End()
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	root := NewRef(buff)
+
+	v, err := root.AsVector()
+	require.NoError(t, err)
+	require.Equal(t, 8, v.Len())
+	i, err := v.IntAt(0)
+	require.NoError(t, err)
+	require.Equal(t, int64(-3), i)
+	u, err := v.UintAt(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(300), u)
+	f, err := v.FloatAt(2)
+	require.NoError(t, err)
+	require.Equal(t, 2.5, f)
+	l, err := v.BoolAt(3)
+	require.NoError(t, err)
+	require.True(t, l)
+	s, err := v.StringAt(4)
+	require.NoError(t, err)
+	require.Equal(t, "str", s)
+	s, err = v.StringAt(5)
+	require.NoError(t, err)
+	require.Equal(t, "key", s)
+	i, err = v.IntAt(6)
+	require.NoError(t, err)
+	require.Equal(t, int64(-70000), i)
+	_, err = v.IntAt(4)
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	_, err = v.StringAt(8)
+	require.ErrorIs(t, err, ErrOutOfBounds)
+	_, err = root.AsTypedVector()
+	require.ErrorIs(t, err, ErrTypeMismatch)
+
+	//slices share the buffer and are indexed from their start
+	sub, err := v.Slice(4, 7)
+	require.NoError(t, err)
+	require.Equal(t, 3, sub.Len())
+	s, err = sub.StringAt(1)
+	require.NoError(t, err)
+	require.Equal(t, "key", s)
+	item, err := sub.At(2)
+	require.NoError(t, err)
+	x, err := item.Interface()
+	require.NoError(t, err)
+	require.Equal(t, int64(-70000), x)
+	_, err = sub.At(3)
+	require.ErrorIs(t, err, ErrOutOfBounds)
+	_, err = sub.Slice(2, 4)
+	require.ErrorIs(t, err, ErrOutOfBounds)
+	empty, err := sub.Slice(3, 3)
+	require.NoError(t, err)
+	require.Equal(t, 0, empty.Len())
+
+	item, err = v.At(7)
+	require.NoError(t, err)
+	m, err := item.AsMap()
+	require.NoError(t, err)
+	require.Equal(t, 3, m.Len())
+	keys := m.Keys()
+	require.Equal(t, VarType(KEY), keys.ElemType())
+	for j, expected := range []string{"a", "b", "c"} {
+		k, err := m.KeyAt(j)
+		require.NoError(t, err)
+		require.Equal(t, expected, k)
+		k, err = keys.StringAt(j)
+		require.NoError(t, err)
+		require.Equal(t, expected, k)
+	}
+	i, err = m.Values().IntAt(2)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), i)
+	tail, err := m.Slice(1, 3)
+	require.NoError(t, err)
+	k, err := tail.KeyAt(0)
+	require.NoError(t, err)
+	require.Equal(t, "b", k)
+	val, err := tail.At(0)
+	require.NoError(t, err)
+	s, err = val.String()
+	require.NoError(t, err)
+	require.Equal(t, "two", s)
+	_, err = root.AsMap()
+	require.ErrorIs(t, err, ErrTypeMismatch)
+
+	tv, err := NewRef(mustMarshal(t, []float32{0.5, 1, 1.5})).AsTypedVector()
+	require.NoError(t, err)
+	require.Equal(t, VarType(FLOAT), tv.ElemType())
+	tail_tv, err := tv.Slice(1, 3)
+	require.NoError(t, err)
+	f, err = tail_tv.FloatAt(1)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, f)
+	_, err = tail_tv.IntAt(0)
+	require.ErrorIs(t, err, ErrTypeMismatch)
+}
+
+func TestGeneric(t *testing.T) {
+	type Celsius float32
+	b := NewBuilder()
+	require.NoError(t, b.StartVector())
+	require.NoError(t, b.Int(-200))
+	require.NoError(t, b.Uint(300))
+	require.NoError(t, b.Float(1e300))
+	require.NoError(t, b.String("str"))
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.StartTypedIntVectorWithKey("temps"))
+	require.NoError(t, b.Int(-40))
+	require.NoError(t, b.Int(100))
+	b.End()
+	b.End()
+	b.End()
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	root, err := Root(buff)
+	require.NoError(t, err)
+	item := func(i int64) Ref {
+		item_ref, err := root.Index(i)
+		require.NoError(t, err)
+		return item_ref
+	}
+
+	i16, err := Get[int16](item(0))
+	require.NoError(t, err)
+	require.Equal(t, int16(-200), i16)
+	_, err = Get[int8](item(0))
+	require.ErrorIs(t, err, ErrOverflow)
+	_, err = Get[uint](item(0))
+	require.ErrorIs(t, err, ErrOverflow)
+	u, err := Get[uint16](item(1))
+	require.NoError(t, err)
+	require.Equal(t, uint16(300), u)
+	_, err = Get[uint8](item(1))
+	require.ErrorIs(t, err, ErrOverflow)
+	f, err := Get[float64](item(2))
+	require.NoError(t, err)
+	require.Equal(t, 1e300, f)
+	_, err = Get[Celsius](item(2))
+	require.ErrorIs(t, err, ErrOverflow)
+	_, err = Get[int](item(2))
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	s, err := Get[string](item(3))
+	require.NoError(t, err)
+	require.Equal(t, "str", s)
+	_, err = Get[bool](item(3))
+	require.ErrorIs(t, err, ErrTypeMismatch)
+
+	temps, err := Lookup[Celsius](root, "4", "temps", "0")
+	require.NoError(t, err)
+	require.Equal(t, Celsius(-40), temps)
+	_, err = Lookup[int8](root, "4", "temps", "2")
+	require.ErrorIs(t, err, ErrOutOfBounds)
+	_, err = Lookup[int8](root, "4", "missing")
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = Lookup[int8](root, "x")
+	require.ErrorIs(t, err, ErrOutOfBounds)
+	_, err = Lookup[int8](root, "0", "0")
+	require.ErrorIs(t, err, ErrTypeMismatch)
+
+	v, err := root.follow([]string{"4", "temps"})
+	require.NoError(t, err)
+	i8s, err := SliceOf[int8](v)
+	require.NoError(t, err)
+	require.Equal(t, []int8{-40, 100}, i8s)
+	_, err = SliceOf[uint32](v)
+	require.ErrorIs(t, err, ErrOverflow)
+	_, err = SliceOf[int](item(3))
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	f32s, err := SliceOf[float32](*NewRef(mustMarshal(t, []interface{}{1, uint(2), 2.5})))
+	require.NoError(t, err)
+	require.Equal(t, []float32{1, 2, 2.5}, f32s)
+
+	//conversions to float types have to round-trip
+	g := func(v interface{}) Ref { return *NewRef(mustMarshal(t, v)) }
+	_, err = Get[float64](g(int64(1)<<53 + 1))
+	require.ErrorIs(t, err, ErrOverflow)
+	_, err = Get[float32](g(int64(1)<<24 + 1))
+	require.ErrorIs(t, err, ErrOverflow)
+	_, err = Get[float64](g(uint64(math.MaxUint64)))
+	require.ErrorIs(t, err, ErrOverflow)
+	_, err = Get[float32](g(0.1))
+	require.ErrorIs(t, err, ErrOverflow)
+	f64, err := Get[float64](g(int64(1) << 53))
+	require.NoError(t, err)
+	require.Equal(t, float64(1<<53), f64)
+	f32, err := Get[float32](g(math.Inf(-1)))
+	require.NoError(t, err)
+	require.True(t, math.IsInf(float64(f32), -1))
+	f32, err = Get[float32](g(math.NaN()))
+	require.NoError(t, err)
+	require.True(t, math.IsNaN(float64(f32)))
+
+	//scalars are read without allocating
+	celsius := g(-40)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := Get[int16](item(0)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Get[Celsius](celsius); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Get[float64](item(2)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	require.Zero(t, allocs)
+}
+
+func TestMapIndex(t *testing.T) {
+	//keys are added in the given order, AutoBuild would sort them
+	addMap := func(keys []string) func(b *Builder) error {
+		return func(b *Builder) error {
+			if err := b.StartMap(); err != nil {
+				return err
+			}
+			for i, k := range keys {
+				if err := b.IntWithKey(k, int64(i)); err != nil {
+					return err
+				}
+			}
+			b.End()
+			return nil
+		}
+	}
+	small := []string{"b", "a", "abc", "ab"}
+	large := make([]string, 12000)
+	for i := range large {
+		//inserted out of order, with keys of different lengths
+		large[i] = fmt.Sprintf("key%d", (i*7919)%len(large))
+	}
+	widths := map[ByteSize]bool{}
+	for _, keys := range [][]string{small, large} {
+		for _, w := range []ByteSize{W8, W16, W32, W64} {
+			buff := mustBuild(t, BuilderOptions{ForceMinBitWidth: w}, addMap(keys))
+			root, err := Root(buff)
+			require.NoError(t, err)
+			kv, err := root.KeyVector()
+			require.NoError(t, err)
+			widths[kv.context.ItemByteSize()] = true
+			m, err := root.AsMap()
+			require.NoError(t, err)
+			for i, k := range keys {
+				val, err := root.MapIndex(k)
+				require.NoError(t, err, k)
+				x, err := val.Int()
+				require.NoError(t, err)
+				require.Equal(t, int64(i), x, k)
+				val, err = m.IndexBytes([]byte(k))
+				require.NoError(t, err, k)
+				x, err = val.Int()
+				require.NoError(t, err)
+				require.Equal(t, int64(i), x, k)
+				x, err = Lookup[int64](root, k)
+				require.NoError(t, err, k)
+				require.Equal(t, int64(i), x, k)
+			}
+			for _, k := range []string{"", "0", "a0", "abcd", "key", "key1x", "key99999", "zzz"} {
+				_, err := root.MapIndex(k)
+				require.ErrorIs(t, err, ErrKeyNotFound, k)
+				_, err = m.Index(k)
+				require.ErrorIs(t, err, ErrKeyNotFound, k)
+			}
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := root.MapIndex(keys[len(keys)/2]); err != nil {
+					t.Fatal(err)
+				}
+			})
+			require.Zero(t, allocs)
+		}
+	}
+	require.Len(t, widths, 4)
+
+	//sliced views only find their own keys
+	m, err := NewRef(mustBuild(t, BuilderOptions{}, addMap(large))).AsMap()
+	require.NoError(t, err)
+	first, err := m.KeyAt(0)
+	require.NoError(t, err)
+	last, err := m.KeyAt(m.Len() - 1)
+	require.NoError(t, err)
+	inner, err := m.Slice(1, m.Len()-1)
+	require.NoError(t, err)
+	_, err = inner.Index(first)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = inner.Index(last)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	second, err := m.KeyAt(1)
+	require.NoError(t, err)
+	_, err = inner.Index(second)
+	require.NoError(t, err)
+
+	//a Finder works on any map with the same keys
+	b := NewBuilderWithOptions(BuilderOptions{ShareKeys: true})
+	require.NoError(t, b.StartVector())
+	for _, name := range []string{"x", "y"} {
+		require.NoError(t, b.StartMap())
+		require.NoError(t, b.StringWithKey("name", name))
+		require.NoError(t, b.IntWithKey("id", 1))
+		b.End()
+	}
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.StringWithKey("name", "z"))
+	b.End()
+	b.End()
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	v, err := NewRef(buff).AsVector()
+	require.NoError(t, err)
+	maps := make([]Map, v.Len())
+	for i := range maps {
+		item, err := v.At(i)
+		require.NoError(t, err)
+		maps[i], err = item.AsMap()
+		require.NoError(t, err)
+	}
+	f, err := maps[0].Finder("name", "missing", "id")
+	require.NoError(t, err)
+	require.Equal(t, 3, f.Len())
+	for i, name := range []string{"x", "y", "z"} {
+		val, err := f.Index(maps[i], 0)
+		require.NoError(t, err)
+		s, err := val.String()
+		require.NoError(t, err)
+		require.Equal(t, name, s)
+		_, err = f.Index(maps[i], 1)
+		require.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	_, err = f.Index(maps[2], 2)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = f.Index(maps[0], 3)
+	require.ErrorIs(t, err, ErrOutOfBounds)
+	require.IsType(t, &RefError{}, err)
+	_, err = f.Index(maps[0], -1)
+	require.ErrorIs(t, err, ErrOutOfBounds)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := f.Index(maps[1], 2); err != nil {
+			t.Fatal(err)
+		}
+	})
+	require.Zero(t, allocs)
+}
+
+func TestScan(t *testing.T) {
+	buff, err := FromJSON(strings.NewReader(`{ "d": 4, "b": [ 1, "x" ], "a": 1, "c": 3 }`))
+	require.NoError(t, err)
+	root, err := Root(buff)
+	require.NoError(t, err)
+
+	var keys []string
+	for k := range root.Keys() {
+		keys = append(keys, k)
+	}
+	require.Equal(t, []string{"a", "b", "c", "d"}, keys)
+	entries := map[string]interface{}{}
+	for k, v := range root.Entries() {
+		x, err := v.Interface()
+		require.NoError(t, err)
+		entries[k] = x
+		if k == "c" {
+			break
+		}
+	}
+	require.Equal(t, map[string]interface{}{"a": int64(1), "b": []interface{}{int64(1), "x"}, "c": int64(3)}, entries)
+	var indices []int
+	for i := range root.All() {
+		indices = append(indices, i)
+	}
+	require.Equal(t, []int{0, 1, 2, 3}, indices)
+
+	b, err := root.Lookup("/b")
+	require.NoError(t, err)
+	var items []interface{}
+	for i, item := range b.All() {
+		require.Equal(t, len(items), i)
+		x, err := item.Interface()
+		require.NoError(t, err)
+		items = append(items, x)
+	}
+	require.Equal(t, []interface{}{int64(1), "x"}, items)
+	for range b.Entries() {
+		t.Fatal("vectors have no entries")
+	}
+	for range b.Keys() {
+		t.Fatal("vectors have no keys")
+	}
+	a, err := root.Lookup("/a")
+	require.NoError(t, err)
+	for range a.All() {
+		t.Fatal("scalars have no items")
+	}
+
+	s, err := root.Scan()
+	require.NoError(t, err)
+	require.True(t, s.IsMap())
+	require.True(t, s.SeekKey("c"))
+	require.True(t, s.Next())
+	require.Equal(t, "c", s.Key())
+	require.Equal(t, 2, s.Index())
+	require.False(t, s.SeekKey("bb"))
+	require.True(t, s.Next())
+	require.Equal(t, "c", s.Key())
+	require.False(t, s.SeekKey("e"))
+	require.False(t, s.Next())
+	require.True(t, s.SeekKey("a"))
+	n := 0
+	for s.Next() {
+		n++
+	}
+	require.Equal(t, 4, n)
+	require.NoError(t, s.Err())
+	require.Equal(t, Ref{}, s.Value())
+	s.Reset()
+	require.True(t, s.Next())
+	require.Equal(t, "a", s.Key())
+	x, err := s.Value().Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), x)
+
+	//errors stop the Scanner
+	corrupt := mustMarshal(t, []interface{}{1, "x"})
+	corrupt[len(corrupt)-4] = 0xff //packed type of the second item
+	v, err := Root(corrupt)
+	require.NoError(t, err)
+	s, err = v.Scan()
+	require.NoError(t, err)
+	require.True(t, s.Next())
+	require.False(t, s.Next())
+	require.ErrorIs(t, s.Err(), ErrCorrupt)
+	require.False(t, s.Next())
+	//the iterators stop at the same item without an error, only the Scanner tells it apart from the end
+	n = 0
+	for range v.All() {
+		n++
+	}
+	require.Equal(t, 1, n)
+}
+
+func TestDetach(t *testing.T) {
+	big := make([]int, 1000)
+	for i := range big {
+		big[i] = i
+	}
+	buff := mustMarshal(t, map[string]interface{}{
+		"a":      "xyz",
+		"big":    big,
+		"n":      5,
+		"nested": map[string]interface{}{"k": []interface{}{1.5, "s"}},
+	})
+	root := *NewRef(buff)
+
+	//the root covers everything but its own offset and the trailing type and width
+	start, end, err := root.ByteRange()
+	require.NoError(t, err)
+	require.Equal(t, 0, start)
+	require.Equal(t, len(buff)-2-int(buff[len(buff)-1]), end)
+
+	a, err := root.MapIndex("a")
+	require.NoError(t, err)
+	start, end, err = a.ByteRange()
+	require.NoError(t, err)
+	require.Equal(t, []byte{3, 'x', 'y', 'z', 0}, buff[start:end])
+
+	n, err := root.MapIndex("n")
+	require.NoError(t, err)
+	start, end, err = n.ByteRange()
+	require.NoError(t, err)
+	require.Equal(t, int(B(root.context.ItemByteSize())), end-start)
+
+	nested, err := root.MapIndex("nested")
+	require.NoError(t, err)
+	start, end, err = nested.ByteRange()
+	require.NoError(t, err)
+	require.Less(t, end-start, 32)
+
+	//a detached value is a standalone copy
+	detached, err := nested.Detach()
+	require.NoError(t, err)
+	require.NoError(t, Verify(detached, VerifyOptions{}))
+	require.Less(t, len(detached), 64)
+	require.True(t, Equal(nested, *NewRef(detached)))
+	require.Equal(t, `{ "k": [ 1.5, "s" ] }`, toJSON(t, detached, JSONOptions{}))
+	scalar, err := n.Detach()
+	require.NoError(t, err)
+	require.Equal(t, mustMarshal(t, 5), scalar)
+	for i := range buff {
+		buff[i] = 0
+	}
+	require.Equal(t, `{ "k": [ 1.5, "s" ] }`, toJSON(t, detached, JSONOptions{}))
+}
+
+//returns the bytes of a string or key, without terminator
+func refBytes(r Ref) []byte {
+	return r.buffer[r.index_0 : r.index_0+r.item_count]
+}
+
+func TestRefOffsets(t *testing.T) {
+	//items after the first one are found at index_0 + i*width, not at i bytes past the first one.
+	//The vector [1, 300, "ab", key "k"] with 2 byte items:
+	r := *NewRef([]byte{2, 'a', 'b', 0, 'k', 0, 4, 0, 1, 0, 44, 1, 11, 0, 10, 0, 4, 5, 20, 16, 12, 41, 1})
+	item_ref, err := r.Index(1)
+	require.NoError(t, err)
+	i, err := item_ref.Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(300), i)
+	item_ref, err = r.Index(2)
+	require.NoError(t, err)
+	require.Equal(t, VarType(STRING), item_ref.context.ItemVarType())
+	require.Equal(t, "ab", string(refBytes(item_ref)))
+	//keys are counted without their terminator
+	item_ref, err = r.Index(3)
+	require.NoError(t, err)
+	require.Equal(t, VarType(KEY), item_ref.context.ItemVarType())
+	require.Equal(t, "k", string(refBytes(item_ref)))
+
+	//the key vector is found with the width of the map, and values are read through Index.
+	//The map {"a": 1, "b": 300} with 2 byte items:
+	r = *NewRef([]byte{'a', 0, 'b', 0, 2, 5, 4, 0, 3, 0, 1, 0, 2, 0, 1, 0, 44, 1, 5, 5, 6, 37, 1})
+	require.Equal(t, uint64(2), r.item_count)
+	m, err := r.Map()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"a": int64(1), "b": int64(300)}, m)
+
+	//an inline root is read with the width of the root
+	r = *NewRef([]byte{44, 1, 4, 2})
+	i, err = r.Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(300), i)
+
+	//items of deprecated string vectors are strings: "a", "b"
+	r = *NewRef([]byte{1, 'a', 0, 1, 'b', 0, 2, 6, 4, 2, 0x3c, 1})
+	item_ref, err = r.Index(1)
+	require.NoError(t, err)
+	require.Equal(t, VarType(STRING), item_ref.context.ItemVarType())
+	require.Equal(t, "b", string(refBytes(item_ref)))
+}
@@ -0,0 +1,153 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+)
+
+//Counters of BuilderOptions.ShareStructures
+type DedupStats struct {
+	Structures int //vectors, maps and blobs that point to an earlier identical one instead of being serialized
+	BytesSaved int //bytes those would have taken, without padding. 0 until the buffer is serialized
+}
+
+//DedupStats reports how much has been saved by sharing identical structures since the last Reset. Widths are only
+//known once the buffer is serialized, so BytesSaved is counted by the last call to Bytes or SerializeBuffer
+func (b *Builder) DedupStats() DedupStats {
+	stats := DedupStats{BytesSaved: b.dedupSaved}
+	for _, n := range b.dedupCounts {
+		stats.Structures += n
+	}
+	return stats
+}
+
+//returns the size of the structures that have been shared, with the widths they have been serialized with
+func (b *Builder) savedBytes() int {
+	saved := 0
+	for o, n := range b.dedupCounts {
+		saved += n * structureSize(o)
+	}
+	return saved
+}
+
+//returns the number of bytes of a serialized structure, from its size prefix to its last packed type
+func structureSize(o iStructure) int {
+	n := o.elemsCount()
+	w := int(B(o.getBsize()))
+	switch x := o.(type) {
+	case *flexMap:
+		return 3*w + n*w + n
+	case *vector:
+		return w + n*w + n
+	case *fixedTypedVector:
+		return n * w
+	case *typedVector:
+		return w + n*w
+	case *blob:
+		return w + len(x.data)
+	}
+	return 0
+}
+
+//structures whose content can be shared, strings and keys are pooled by value instead
+func isDedupable(o iStructure) bool {
+	switch o.(type) {
+	case *flexMap, *vector, *typedVector, *fixedTypedVector, *blob:
+		return true
+	}
+	return false
+}
+
+//returns the hash of the content of a finished structure. Its children have been deduplicated or pooled already, so
+//offsets are hashed by the identity of their target
+func digest(o iStructure) hash64 {
+	h := hash64(fnvOffset64).byte(byte(o.getVtype()))
+	s := o.base()
+	h = h.byte(byte(s.minBSize)).uint64(uint64(len(s.elems)))
+	for _, e := range s.elems {
+		h = h.byte(byte(e.fieldType)).byte(byte(e.fieldSize))
+		if e.target != nil {
+			h = h.uint64(uint64(reflect.ValueOf(e.target).Pointer()))
+		} else {
+			h = h.uint64(binary.LittleEndian.Uint64(e.bytes[:]))
+		}
+	}
+	switch x := o.(type) {
+	case *flexMap:
+		for _, e := range x.keys.elems {
+			h = h.uint64(uint64(reflect.ValueOf(e.target).Pointer()))
+		}
+	case *blob:
+		h = h.bytes(x.data)
+	}
+	return h
+}
+
+func sameElems(a []*element, b []*element) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if x.fieldType != y.fieldType || x.fieldSize != y.fieldSize || x.target != y.target ||
+			x.target == nil && x.bytes != y.bytes {
+			return false
+		}
+	}
+	return true
+}
+
+//reports whether two finished structures have the same content
+func sameContent(a iStructure, b iStructure) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || a.getVtype() != b.getVtype() {
+		return false
+	}
+	x, y := a.base(), b.base()
+	if x.minBSize != y.minBSize || !sameElems(x.elems, y.elems) {
+		return false
+	}
+	switch m := a.(type) {
+	case *flexMap:
+		return sameElems(m.keys.elems, b.(*flexMap).keys.elems)
+	case *blob:
+		return bytes.Equal(m.data, b.(*blob).data)
+	}
+	return true
+}
+
+//replaces a finished structure by an identical one added earlier, if structures are shared. It reports whether o
+//has been replaced, in which case o is not part of the buffer anymore
+func (b *Builder) dedup(o iStructure, parent iStructure) bool {
+	if !b.options.ShareStructures || !isDedupable(o) {
+		return false
+	}
+	h := digest(o)
+	for _, pooled := range b.structurePool[h] {
+		if !sameContent(o, pooled) {
+			continue
+		}
+		s := o.base()
+		for _, off := range s.offsetPtrs {
+			off.target = pooled
+			pooled.bindOffset(off)
+		}
+		p := parent.base()
+		for i := len(p.children) - 1; i >= 0; i-- {
+			if p.children[i] == o {
+				p.children = append(p.children[:i], p.children[i+1:]...)
+				break
+			}
+		}
+		if b.dedupCounts == nil {
+			b.dedupCounts = map[iStructure]int{}
+		}
+		b.dedupCounts[pooled]++
+		return true
+	}
+	if b.structurePool == nil {
+		b.structurePool = map[hash64][]iStructure{}
+	}
+	b.structurePool[h] = append(b.structurePool[h], o)
+	return false
+}
@@ -13,6 +13,14 @@ const (
 	b64                 // 2^3 = 8 bytes
 )
 
+//Bit widths for BuilderOptions.ForceMinBitWidth
+const (
+	W8  = b8
+	W16 = b16
+	W32 = b32
+	W64 = b64
+)
+
 type VarType uint8
 
 const (
@@ -0,0 +1,104 @@
+package flexbuffers
+
+//Detach returns a new flexbuffer whose root is a copy of the value, so that the buffer r belongs to can be released.
+//Types are kept and byte widths are chosen again, see Builder.AddRef
+func (r Ref) Detach() ([]byte, error) {
+	b := NewBuilder()
+	if err := b.AddRef(r); err != nil {
+		return nil, err
+	}
+	return b.Bytes()
+}
+
+//ByteRange returns the smallest range [start, end) of the buffer that holds all the bytes of the value: its size
+//prefix, items, packed types, key vector and everything they refer to. The slot of the parent pointing to the value
+//is not included, unless the value is stored inline in it. Strings, keys and structures shared with other values are
+//part of the range as well, so the range may include bytes of unrelated values lying in between
+func (r Ref) ByteRange() (int, int, error) {
+	s := span{start: uint64(len(r.buffer)), seen: map[refID]bool{}}
+	if err := s.add(r, 0); err != nil {
+		return 0, 0, err
+	}
+	if s.end > uint64(len(r.buffer)) {
+		return 0, 0, r.corrupt(s.end, "value ends out of bounds of a buffer of %d bytes", len(r.buffer))
+	}
+	return int(s.start), int(s.end), nil
+}
+
+//identifies a value within a buffer
+type refID struct {
+	index_0 uint64
+	con     context
+}
+
+//the range of bytes covered by a value and the structures it refers to, each one visited once
+type span struct {
+	start, end uint64
+	seen       map[refID]bool
+}
+
+func (s *span) extend(start uint64, end uint64) {
+	s.start = min(s.start, start)
+	s.end = max(s.end, end)
+}
+
+func (s *span) add(r Ref, depth int) error {
+	if err := r.checkDepth(depth); err != nil {
+		return err
+	}
+	vType := r.context.ItemVarType()
+	bWidth := B(r.context.ItemByteSize())
+	items_end := r.index_0 + r.item_count*bWidth
+	switch true {
+	case isInline(vType):
+		s.extend(r.index_0, r.index_0+bWidth)
+		return nil
+	case vType == KEY:
+		s.extend(r.index_0, r.index_0+r.item_count+1)
+		return nil
+	case vType == STRING:
+		s.extend(r.index_0-bWidth, r.index_0+r.item_count+1)
+		return nil
+	case vType == BLOB:
+		s.extend(r.index_0-bWidth, r.index_0+r.item_count)
+		return nil
+	case isFixedTypedVector(vType):
+		s.extend(r.index_0, items_end)
+		return nil
+	}
+	id := refID{r.index_0, r.context}
+	if s.seen[id] {
+		return nil
+	}
+	s.seen[id] = true
+	switch true {
+	case vType == MAP:
+		s.extend(r.index_0-3*bWidth, items_end+r.item_count)
+		kv, err := r.KeyVector()
+		if err != nil {
+			return err
+		}
+		if err := s.add(kv, depth+1); err != nil {
+			return err
+		}
+	case vType == VECTOR:
+		s.extend(r.index_0-bWidth, items_end+r.item_count)
+	case isTypedVector(vType):
+		s.extend(r.index_0-bWidth, items_end)
+		if vType != VECTOR_KEY && vType != VECTOR_STRING_DEPRECATED {
+			return nil
+		}
+	default:
+		return r.corrupt(r.index_0, "unknown type %s", vType.toString())
+	}
+	for i := int64(0); i < int64(r.item_count); i++ {
+		item_ref, err := r.Index(i)
+		if err != nil {
+			return err
+		}
+		if err := s.add(item_ref, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -666,6 +666,44 @@ func BenchmarkNewBuilder(b *testing.B) {
 	}
 }
 
+type benchmarkMessage struct {
+	ID     int64   `flexbuffers:"id"`
+	Name   string  `flexbuffers:"name"`
+	Score  float64 `flexbuffers:"score"`
+	Tags   []string
+	Counts []int
+	Pos    [3]float32
+	Next   *benchmarkMessage
+}
+
+var benchmarkValue = &benchmarkMessage{ID: 1, Name: "first", Score: 0.5, Tags: []string{"a", "b"}, Counts: []int{1, 2, 300},
+	Next: &benchmarkMessage{ID: 2, Name: "second", Pos: [3]float32{1, 2, 3}}}
+
+func BenchmarkMarshalAppend(b *testing.B) {
+	b.ReportAllocs()
+	buff := make([]byte, 0, 1024)
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buff, err = MarshalAppend(buff[:0], benchmarkValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuilderReset(b *testing.B) {
+	b.ReportAllocs()
+	B := NewBuilder()
+	for i := 0; i < b.N; i++ {
+		B.Reset()
+		if err := B.AutoBuild(benchmarkValue); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := B.Bytes(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 /*
 func encodeB(v json.RawMessage) ([]byte, error) {
 	var vv interface{}
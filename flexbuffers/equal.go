@@ -0,0 +1,323 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"math"
+)
+
+//Logical kinds of values, in the order used by Compare. Byte widths, indirection, typed and untyped vectors and shared
+//strings only affect the layout of a value, not its kind
+type kind uint8
+
+const (
+	kindNull kind = iota
+	kindBool
+	kindInt    //INT, UINT and their indirect forms
+	kindFloat  //FLOAT and INDIRECT_FLOAT
+	kindString //STRING and KEY
+	kindBlob
+	kindVector //untyped, typed and fixed typed vectors
+	kindMap
+	kindInvalid //values that can not be read
+)
+
+func (r Ref) kind() kind {
+	vType := r.context.ItemVarType()
+	switch true {
+	case vType == NULL:
+		return kindNull
+	case vType == BOOL:
+		return kindBool
+	case vType == INT, vType == UINT, vType == INDIRECT_INT, vType == INDIRECT_UINT:
+		return kindInt
+	case vType == FLOAT, vType == INDIRECT_FLOAT:
+		return kindFloat
+	case vType == STRING, vType == KEY:
+		return kindString
+	case vType == BLOB:
+		return kindBlob
+	case vType == MAP:
+		return kindMap
+	case isVector(vType):
+		return kindVector
+	}
+	return kindInvalid
+}
+
+//a value being compared or hashed, bad if it could not be read
+type logical struct {
+	ref Ref
+	bad bool
+}
+
+func (l logical) kind() kind {
+	if l.bad {
+		return kindInvalid
+	}
+	return l.ref.kind()
+}
+
+//reads an integer as its sign and magnitude, so that INT and UINT values can be compared
+func (r Ref) integer() (bool, uint64, error) {
+	vType := r.context.ItemVarType()
+	if vType == UINT || vType == INDIRECT_UINT {
+		u, err := r.Uint()
+		return false, u, err
+	}
+	i, err := r.Int()
+	if i < 0 {
+		return true, uint64(-(i + 1)) + 1, err
+	}
+	return false, uint64(i), err
+}
+
+//floats are ordered numerically, with -0 equal to +0 and every NaN equal to each other and greater than any number
+func compareFloats(x float64, y float64) int {
+	switch true {
+	case math.IsNaN(x) && math.IsNaN(y):
+		return 0
+	case math.IsNaN(x):
+		return 1
+	case math.IsNaN(y):
+		return -1
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func compareUints(x uint64, y uint64) int {
+	switch true {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+//Equal reports whether a and b hold the same logical value, see Compare
+func Equal(a, b Ref) bool {
+	return Compare(a, b) == 0
+}
+
+//Compare orders values by their logical content and returns -1, 0 or +1. Values of different kinds are ordered
+//null < bool < integer < float < string < blob < vector < map. INT and UINT values are compared by their numeric value,
+//floats numerically with -0 equal to +0 and NaN greater than any number. Strings, keys and blobs are compared bytewise,
+//vectors item by item and maps entry by entry in key order, shorter ones first when one is a prefix of the other.
+//Byte widths, indirection, typed or untyped vectors and shared strings are not taken into account. Values that can not
+//be read, or are nested deeper than the decoders accept, are greater than any other value and, like NaN in Go, never
+//equal to anything: two of them compare as +1, so that Equal and Diff never take corrupt data for unchanged data
+func Compare(a, b Ref) int {
+	return compareLogical(logical{ref: a}, logical{ref: b}, 0)
+}
+
+//returns the item at index i of r, flagged as bad if it can not be read
+func (r Ref) logicalItem(i int64) logical {
+	item_ref, err := r.Index(i)
+	return logical{item_ref, err != nil}
+}
+
+//returns the contents of a string, key or blob
+func (r Ref) contents() ([]byte, error) {
+	return r.getBytes(r.index_0, r.item_count)
+}
+
+func compareLogical(a logical, b logical, depth int) int {
+	if depth > maxNestingDepth {
+		a.bad, b.bad = true, true
+	}
+	ka, kb := a.kind(), b.kind()
+	if ka != kb {
+		return compareUints(uint64(ka), uint64(kb))
+	}
+	x, y := a.ref, b.ref
+	//each case falls through to compare the values as invalid if one of them can not be read
+	switch ka {
+	case kindNull:
+		return 0
+	case kindInvalid:
+		return 1
+	case kindBool:
+		l, err1 := x.Bool()
+		m, err2 := y.Bool()
+		if err1 == nil && err2 == nil {
+			switch true {
+			case l == m:
+				return 0
+			case m:
+				return -1
+			}
+			return 1
+		}
+	case kindInt:
+		neg1, mag1, err1 := x.integer()
+		neg2, mag2, err2 := y.integer()
+		if err1 == nil && err2 == nil {
+			switch true {
+			case neg1 && !neg2:
+				return -1
+			case !neg1 && neg2:
+				return 1
+			case neg1:
+				return compareUints(mag2, mag1)
+			}
+			return compareUints(mag1, mag2)
+		}
+	case kindFloat:
+		f, err1 := x.Float()
+		g, err2 := y.Float()
+		if err1 == nil && err2 == nil {
+			return compareFloats(f, g)
+		}
+	case kindString, kindBlob:
+		s, err1 := x.contents()
+		t, err2 := y.contents()
+		if err1 == nil && err2 == nil {
+			return bytes.Compare(s, t)
+		}
+	case kindVector:
+		n := min(x.item_count, y.item_count)
+		for i := int64(0); i < int64(n); i++ {
+			if c := compareLogical(x.logicalItem(i), y.logicalItem(i), depth+1); c != 0 {
+				return c
+			}
+		}
+		return compareUints(x.item_count, y.item_count)
+	case kindMap:
+		kv1, err1 := x.KeyVector()
+		kv2, err2 := y.KeyVector()
+		if err1 != nil || err2 != nil {
+			break
+		}
+		n := min(x.item_count, y.item_count)
+		for i := int64(0); i < int64(n); i++ {
+			k1, err1 := kv1.keyBytesAt(i)
+			k2, err2 := kv2.keyBytesAt(i)
+			if err1 != nil || err2 != nil {
+				return compareLogical(logical{bad: err1 != nil}, logical{bad: err2 != nil}, depth+1)
+			}
+			if c := bytes.Compare(k1, k2); c != 0 {
+				return c
+			}
+			if c := compareLogical(x.logicalItem(i), y.logicalItem(i), depth+1); c != 0 {
+				return c
+			}
+		}
+		return compareUints(x.item_count, y.item_count)
+	}
+	return compareLogical(logical{bad: x.kindErr(ka) != nil}, logical{bad: y.kindErr(ka) != nil}, depth+1)
+}
+
+//returns the error reading the contents of a value of the given kind, if any
+func (r Ref) kindErr(k kind) error {
+	var err error
+	switch k {
+	case kindBool:
+		_, err = r.Bool()
+	case kindInt:
+		_, _, err = r.integer()
+	case kindFloat:
+		_, err = r.Float()
+	case kindString, kindBlob:
+		_, err = r.contents()
+	case kindMap:
+		_, err = r.KeyVector()
+	}
+	return err
+}
+
+//FNV-1a, which does not depend on the process like hash/maphash does
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+type hash64 uint64
+
+func (h hash64) byte(c byte) hash64 {
+	return (h ^ hash64(c)) * fnvPrime64
+}
+
+func (h hash64) uint64(u uint64) hash64 {
+	for i := 0; i < 8; i++ {
+		h = h.byte(byte(u >> (8 * i)))
+	}
+	return h
+}
+
+func (h hash64) bytes(data []byte) hash64 {
+	h = h.uint64(uint64(len(data)))
+	for _, c := range data {
+		h = h.byte(c)
+	}
+	return h
+}
+
+//Hash64 returns a hash of the logical value, that is the same for all values Equal to each other, in this process or
+//any other. It is meant for caching and deduplication, not for security
+func (r Ref) Hash64() uint64 {
+	return uint64(logical{ref: r}.hash(fnvOffset64, 0))
+}
+
+func (l logical) hash(h hash64, depth int) hash64 {
+	if depth > maxNestingDepth {
+		l.bad = true
+	}
+	k := l.kind()
+	r := l.ref
+	switch k {
+	case kindBool:
+		if x, err := r.Bool(); err == nil {
+			if x {
+				return h.byte(byte(k)).byte(1)
+			}
+			return h.byte(byte(k)).byte(0)
+		}
+	case kindInt:
+		if neg, mag, err := r.integer(); err == nil {
+			if neg {
+				return h.byte(byte(k)).byte(1).uint64(mag)
+			}
+			return h.byte(byte(k)).byte(0).uint64(mag)
+		}
+	case kindFloat:
+		if f, err := r.Float(); err == nil {
+			switch true {
+			case math.IsNaN(f):
+				f = math.NaN()
+			case f == 0:
+				f = 0
+			}
+			return h.byte(byte(k)).uint64(math.Float64bits(f))
+		}
+	case kindString, kindBlob:
+		if data, err := r.contents(); err == nil {
+			return h.byte(byte(k)).bytes(data)
+		}
+	case kindVector:
+		h = h.byte(byte(k)).uint64(r.item_count)
+		for i := int64(0); i < int64(r.item_count); i++ {
+			h = r.logicalItem(i).hash(h, depth+1)
+		}
+		return h
+	case kindMap:
+		if kv, err := r.KeyVector(); err == nil {
+			h = h.byte(byte(k)).uint64(r.item_count)
+			for i := int64(0); i < int64(r.item_count); i++ {
+				key, err := kv.keyBytesAt(i)
+				if err != nil {
+					h = logical{bad: true}.hash(h, depth+1)
+					continue
+				}
+				h = r.logicalItem(i).hash(h.bytes(key), depth+1)
+			}
+			return h
+		}
+	case kindNull:
+		return h.byte(byte(k))
+	}
+	return h.byte(byte(kindInvalid))
+}
@@ -0,0 +1,102 @@
+package flexbuffers
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEqual(t *testing.T) {
+	fromJSON := func(s string) Ref {
+		buff, err := FromJSON(strings.NewReader(s))
+		require.NoError(t, err, s)
+		return *NewRef(buff)
+	}
+	in := `{ "name": "x", "tags": [ "a", "a", "b" ], "ints": [ 1, -2, 300 ], "f": 1.5, "n": null, "ok": true }`
+	a := fromJSON(in)
+
+	//same content, different layout: wide, unshared, typed and indirect values
+	b := NewBuilderWithOptions(BuilderOptions{ForceMinBitWidth: W64})
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.StringWithKey("name", "x"))
+	require.NoError(t, b.StartVectorWithKey("tags"))
+	require.NoError(t, b.Key("a"))
+	require.NoError(t, b.String("a"))
+	require.NoError(t, b.Key("b"))
+	b.End()
+	require.NoError(t, b.StartTypedIntVectorWithKey("ints"))
+	require.NoError(t, b.Int(1))
+	require.NoError(t, b.Int(-2))
+	require.NoError(t, b.Int(300))
+	b.End()
+	require.NoError(t, b.StartFloatScalarWithKey("f"))
+	require.NoError(t, b.Float(1.5))
+	b.End()
+	require.NoError(t, b.NullWithKey("n"))
+	require.NoError(t, b.BoolWithKey("ok", true))
+	b.End()
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	c := *NewRef(buff)
+	require.NotEqual(t, a.buffer, c.buffer)
+	require.True(t, Equal(a, c))
+	require.Equal(t, 0, Compare(c, a))
+	require.Equal(t, a.Hash64(), c.Hash64())
+
+	//ordered lists of values, each one less than the next
+	ordered := []string{
+		`null`, `false`, `true`, `-9223372036854775808`, `-1`, `0`, `9223372036854775807`, `18446744073709551615`,
+		`-1e300`, `-0.5`, `0.0`, `2.5`, `""`, `"a"`, `"ab"`, `"b"`, `[]`, `[ 1 ]`, `[ 1, 2 ]`, `[ 2 ]`, `[ "a" ]`,
+		`{}`, `{ "a": 1 }`, `{ "a": 1, "b": 1 }`, `{ "a": 2 }`, `{ "b": 0 }`,
+	}
+	refs := make([]Ref, len(ordered))
+	for i, s := range ordered {
+		refs[i] = fromJSON(s)
+	}
+	for i := range refs {
+		for j := range refs {
+			expected := 0
+			switch true {
+			case i < j:
+				expected = -1
+			case i > j:
+				expected = 1
+			}
+			require.Equal(t, expected, Compare(refs[i], refs[j]), "%s %s", ordered[i], ordered[j])
+			require.Equal(t, i == j, Equal(refs[i], refs[j]), "%s %s", ordered[i], ordered[j])
+			if i != j {
+				require.NotEqual(t, refs[i].Hash64(), refs[j].Hash64(), "%s %s", ordered[i], ordered[j])
+			}
+		}
+	}
+
+	//integers compare by value whether they are INT or UINT, floats by value whatever their width
+	require.True(t, Equal(*NewRef(mustMarshal(t, uint8(7))), *NewRef(mustMarshal(t, int64(7)))))
+	require.Equal(t, NewRef(mustMarshal(t, uint8(7))).Hash64(), NewRef(mustMarshal(t, int64(7))).Hash64())
+	require.True(t, Equal(*NewRef(mustMarshal(t, float32(0.5))), *NewRef(mustMarshal(t, 0.5))))
+	require.False(t, Equal(*NewRef(mustMarshal(t, float32(0.1))), *NewRef(mustMarshal(t, 0.1))))
+	require.False(t, Equal(*NewRef(mustMarshal(t, 1)), *NewRef(mustMarshal(t, 1.0))))
+	require.True(t, Equal(*NewRef(mustMarshal(t, []int8{1, 2})), *NewRef(mustMarshal(t, []interface{}{1, uint(2)}))))
+	require.True(t, Equal(*NewRef(mustMarshal(t, []byte("ab"))), *NewRef(mustMarshal(t, []byte("ab")))))
+	require.False(t, Equal(*NewRef(mustMarshal(t, []byte("ab"))), fromJSON(`"ab"`)))
+
+	//-0 equals +0 and NaN equals NaN, so that the order is total
+	zero, negZero, nan := *NewRef(mustMarshal(t, 0.0)), *NewRef(mustMarshal(t, math.Copysign(0, -1))), *NewRef(mustMarshal(t, math.NaN()))
+	require.True(t, Equal(zero, negZero))
+	require.Equal(t, zero.Hash64(), negZero.Hash64())
+	require.True(t, Equal(nan, nan))
+	require.Equal(t, 1, Compare(nan, *NewRef(mustMarshal(t, math.Inf(1)))))
+
+	//unreadable values are greater than any other value and not equal to anything, not even themselves
+	corrupt := mustMarshal(t, []interface{}{1, "x"})
+	corrupt[len(corrupt)-4] = 0xff
+	require.Equal(t, 1, Compare(*NewRef(corrupt), *NewRef(mustMarshal(t, []interface{}{1, map[string]int{"a": 1}}))))
+	require.Equal(t, -1, Compare(*NewRef(mustMarshal(t, []interface{}{1, map[string]int{"a": 1}})), *NewRef(corrupt)))
+	require.False(t, Equal(*NewRef(corrupt), *NewRef(corrupt)))
+	other := append([]byte(nil), corrupt...)
+	other[1] = 'y'
+	require.False(t, Equal(*NewRef(corrupt), *NewRef(other)))
+	require.Equal(t, NewRef(corrupt).Hash64(), NewRef(corrupt).Hash64())
+}
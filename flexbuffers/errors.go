@@ -0,0 +1,97 @@
+package flexbuffers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+//Sentinel errors reported by Ref methods and the generic accessors. They are wrapped in a *RefError, use errors.Is
+//to check for them
+var (
+	ErrTypeMismatch   = errors.New("type mismatch")           //the value does not have the requested type
+	ErrOutOfBounds    = errors.New("index out of bounds")     //the index is negative or not less than the number of items
+	ErrKeyNotFound    = errors.New("key not found")           //the map does not contain the key
+	ErrCorrupt        = errors.New("flexbuffer is corrupted") //an offset, width, type or size does not fit the buffer
+	ErrOverflow       = errors.New("value out of range")      //the value does not fit in the requested Go type
+	ErrTooLarge       = errors.New("flexbuffer is too large") //the buffer exceeds VerifyOptions.MaxSize
+	ErrInvalidPointer = errors.New("invalid JSON pointer")    //the JSON pointer given to Lookup or CompilePath is malformed
+)
+
+//A RefError describes a failed access to a flexbuffer. Err is one of ErrTypeMismatch, ErrOutOfBounds, ErrKeyNotFound,
+//ErrCorrupt, ErrOverflow, ErrTooLarge and ErrInvalidPointer, Offset is the position in the buffer where decoding failed
+type RefError struct {
+	Err    error
+	Offset uint64
+	msg    string
+	path   string //segments followed from the Ref the failed call has been made on, e.g. .users[3].name
+}
+
+func (e *RefError) Error() string {
+	if e.Err == ErrInvalidPointer {
+		//the pointer is rejected before the buffer is read
+		return fmt.Sprintf("flexbuffers: %s %s", e.Err, e.msg)
+	}
+	where := fmt.Sprintf("offset %d", e.Offset)
+	if e.path != "" {
+		where = fmt.Sprintf("%s (offset %d)", e.Path(), e.Offset)
+	}
+	return fmt.Sprintf("flexbuffers: %s at %s: %s", e.Err, where, e.msg)
+}
+
+func (e *RefError) Unwrap() error {
+	return e.Err
+}
+
+//Path returns the access path of the failure, e.g. $.users[3].name, where $ stands for the Ref the failed call has been
+//made on. Refs do not keep track of how they have been reached, so the path only holds the keys and indices followed
+//by that call: the segments of a lookup, or the items visited by a recursive decoder such as Interface or Unmarshal
+func (e *RefError) Path() string {
+	return "$" + e.path
+}
+
+func (r Ref) newError(sentinel error, offset uint64, segment string, format string, args ...interface{}) error {
+	return &RefError{
+		Err:    sentinel,
+		Offset: offset,
+		msg:    fmt.Sprintf(format, args...),
+		path:   segment,
+	}
+}
+
+//prefixes the path of the *RefError in err, if any, with the segment that led to the value it occurred in. Only called
+//on failure, so that successful accesses do not build paths
+func atSegment(err error, segment string) error {
+	var e *RefError
+	if errors.As(err, &e) {
+		e.path = segment + e.path
+	}
+	return err
+}
+
+func (r Ref) mismatch(format string, args ...interface{}) error {
+	return r.newError(ErrTypeMismatch, r.index_0, "", format, args...)
+}
+
+func (r Ref) corrupt(offset uint64, format string, args ...interface{}) error {
+	return r.newError(ErrCorrupt, offset, "", format, args...)
+}
+
+func (r Ref) outOfBounds(i int64) error {
+	return r.newError(ErrOutOfBounds, r.index_0, indexSegment(i), "index %d out of %d", i, r.item_count)
+}
+
+func (r Ref) keyNotFound(key string) error {
+	return r.newError(ErrKeyNotFound, r.index_0, keySegment(key), "map of %d key(s) does not contain %q", r.item_count, key)
+}
+
+func indexSegment(i int64) string {
+	return "[" + strconv.FormatInt(i, 10) + "]"
+}
+
+func keySegment(key string) string {
+	if isIdentifier([]byte(key)) {
+		return "." + key
+	}
+	return "[" + strconv.Quote(key) + "]"
+}
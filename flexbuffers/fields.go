@@ -0,0 +1,223 @@
+package flexbuffers
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//A struct field that takes part in encoding/decoding. Fields are described by the `flexbuffers:"name,omitempty"` tag,
+//fields tagged with "-" and unexported fields are skipped
+type field struct {
+	name      string
+	index     []int //index sequence for reflect.Value.FieldByIndex (embedded structs are flattened)
+	typ       reflect.Type
+	omitEmpty bool
+	tagged    bool //the name comes from the tag
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+func parseTag(tag string) (string, bool) {
+	name := tag
+	omitEmpty := false
+	if i := strings.Index(tag, ","); i >= 0 {
+		name = tag[:i]
+		for _, opt := range strings.Split(tag[i+1:], ",") {
+			if opt == "omitempty" {
+				omitEmpty = true
+			}
+		}
+	}
+	return name, omitEmpty
+}
+
+func typeFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	fields := dominantFields(collectFields(t))
+	f, _ := fieldCache.LoadOrStore(t, fields)
+	return f.([]field)
+}
+
+//an embedded struct whose fields are flattened into the struct it is embedded in
+type embeddedStruct struct {
+	typ   reflect.Type
+	index []int
+}
+
+//collects the fields of t, including the fields of untagged embedded structs. Embedded structs are walked breadth-first
+//and every struct type is only expanded at the shallowest depth it is embedded at, so that types embedding themselves
+//(directly or through other types) are flattened once, like in encoding/json
+func collectFields(t reflect.Type) []field {
+	fields := []field{}
+	visited := map[reflect.Type]bool{}
+	next := []embeddedStruct{{typ: t}}
+	for len(next) > 0 {
+		level := next[:0:0]
+		for _, e := range next {
+			if !visited[e.typ] {
+				level = append(level, e)
+			}
+		}
+		//a type embedded several times at the same depth is expanded for each of them, its fields are ambiguous then
+		for _, e := range level {
+			visited[e.typ] = true
+		}
+		next = nil
+		for _, e := range level {
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				tag := sf.Tag.Get("flexbuffers")
+				if tag == "-" {
+					continue
+				}
+				name, omitEmpty := parseTag(tag)
+				fieldIndex := append(append([]int{}, e.index...), i)
+				ft := sf.Type
+				if sf.Anonymous && name == "" {
+					//untagged embedded structs are flattened into the parent
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Struct {
+						next = append(next, embeddedStruct{typ: ft, index: fieldIndex})
+						continue
+					}
+				}
+				if sf.PkgPath != "" { //unexported
+					continue
+				}
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+				}
+				fields = append(fields, field{name: name, index: fieldIndex, typ: sf.Type, omitEmpty: omitEmpty, tagged: tagged})
+			}
+		}
+	}
+	//fields are kept in declaration order, with the fields of embedded structs in place of the struct
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return fields
+}
+
+//when several fields share a name, the least nested one wins. If several are nested equally deep, the one with a
+//tagged name wins, otherwise the name is ambiguous and all of them are dropped, like in encoding/json
+func dominantFields(fields []field) []field {
+	names := []string{}
+	byName := map[string][]field{}
+	for _, f := range fields {
+		if _, ok := byName[f.name]; !ok {
+			names = append(names, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	result := []field{}
+	for _, name := range names {
+		if f, ok := dominantField(byName[name]); ok {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func dominantField(fields []field) (field, bool) {
+	var least []field
+	for _, f := range fields {
+		switch true {
+		case len(least) == 0 || len(f.index) < len(least[0].index):
+			least = append(least[:0], f)
+		case len(f.index) == len(least[0].index):
+			least = append(least, f)
+		}
+	}
+	if len(least) == 1 {
+		return least[0], true
+	}
+	var dominant field
+	found := false
+	for _, f := range least {
+		if !f.tagged {
+			continue
+		}
+		if found {
+			return field{}, false
+		}
+		dominant, found = f, true
+	}
+	return dominant, found
+}
+
+//looks up a field by name, exact matches take precedence over case-insensitive matches
+func fieldByName(fields []field, name string) *field {
+	var fold *field
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+		if fold == nil && strings.EqualFold(fields[i].name, name) {
+			fold = &fields[i]
+		}
+	}
+	return fold
+}
+
+//like reflect.Value.FieldByIndex, but allocates nil embedded struct pointers on the way
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("unable to set embedded pointer to unexported struct %s", v.Type().Elem().String())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+//like reflect.Value.FieldByIndex, but reports false instead of panicking on nil embedded struct pointers
+func fieldByIndexNoAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
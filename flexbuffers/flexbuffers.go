@@ -1,8 +1,6 @@
 package flexbuffers
 
-import (
-	"bytes"
-)
+import "sync"
 
 type Offset uint32
 
@@ -62,16 +60,39 @@ func isBoolTyped(vType VarType) bool {
 	return vType == VECTOR_BOOL
 }
 
-type Decoder struct {
-	Reader bytes.Buffer
+//Marshaler is implemented by types that write their own flexbuffers representation. MarshalFlexBuffer must add exactly
+//one value to the builder using the methods without a key - inside a map, the key is supplied by the caller
+type Marshaler interface {
+	MarshalFlexBuffer(*Builder) error
 }
 
+//Unmarshaler is implemented by types that decode their own flexbuffers representation
+type Unmarshaler interface {
+	UnmarshalFlexBuffer(Ref) error
+}
+
+var builderPool = sync.Pool{New: func() interface{} { return NewBuilder() }}
+
+//Marshal returns the flexbuffer of item, see Builder.AutoBuild
 func Marshal(item interface{}) ([]byte, error) {
-	b := NewBuilder()
+	return MarshalAppend(nil, item)
+}
+
+//MarshalAppend appends the flexbuffer of item to dst and returns the extended buffer. Builders are taken from a pool
+//and reset after use, so once dst has enough capacity, marshaling values of a similar shape does not allocate
+//(except for maps, whose keys are sorted with reflection)
+func MarshalAppend(dst []byte, item interface{}) ([]byte, error) {
+	b := builderPool.Get().(*Builder)
+	defer func() {
+		b.Reset()
+		builderPool.Put(b)
+	}()
 	if err := b.AutoBuild(item); err != nil {
-		return nil, err
+		return dst, err
+	}
+	buff, err := b.Bytes()
+	if err != nil {
+		return dst, err
 	}
-	buff := []byte{}
-	_, err := b.SerializeBuffer(&buff)
-	return buff, err
+	return append(dst, buff...), nil
 }
@@ -0,0 +1,214 @@
+package flexbuffers
+
+import (
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+//Integer types supported by Get, SliceOf and Lookup
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+//Float types supported by Get, SliceOf and Lookup
+type Float interface {
+	~float32 | ~float64
+}
+
+//Go types a single flexbuffers value can be read into with Get, SliceOf and Lookup
+type Scalar interface {
+	Integer | Float | ~bool | ~string
+}
+
+//Get reads the value referenced by r into a T. INT, UINT and their indirect forms can be read into any integer or
+//float type, FLOAT and INDIRECT_FLOAT into float types, BOOL into bool types and STRING and KEY into string types.
+//Values that T can not hold exactly are reported with ErrOverflow instead of being truncated or rounded: integers out
+//of range, integers that a float type can not represent and floats that do not survive narrowing to float32
+func Get[T Scalar](r Ref) (T, error) {
+	var x T
+	//named types such as Celsius are not matched by a type switch, so T is dispatched on its underlying kind
+	err := r.getScalar(unsafe.Pointer(&x), reflect.TypeFor[T]())
+	return x, err
+}
+
+//SliceOf reads the items of an untyped, typed or fixed typed vector into a []T, converting every item like Get
+func SliceOf[T Scalar](r Ref) ([]T, error) {
+	v, err := r.AsVector()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]T, v.Len())
+	for i := range result {
+		item_ref, err := v.At(i)
+		if err != nil {
+			return nil, err
+		}
+		if result[i], err = Get[T](item_ref); err != nil {
+			return nil, atSegment(err, indexSegment(int64(i)))
+		}
+	}
+	return result, nil
+}
+
+//Lookup follows the path from r and reads the value it leads to like Get. Every segment of the path is either a key
+//of a map or the decimal index of an item of a vector
+func Lookup[T Scalar](r Ref, path ...string) (T, error) {
+	target, err := r.follow(path)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	x, err := Get[T](target)
+	if err != nil {
+		return x, atSegment(err, r.followedPath(toSegments(path)))
+	}
+	return x, nil
+}
+
+//follows path segments, see Lookup
+func (r Ref) follow(path []string) (Ref, error) {
+	from := r
+	for i, segment := range path {
+		key := *(*[]byte)(unsafe.Pointer(&segment))
+		var err error
+		if r, err = r.step(key, parseIndex(key)); err != nil {
+			return Ref{}, atSegment(err, from.followedPath(toSegments(path[:i])))
+		}
+	}
+	return r, nil
+}
+
+func toSegments(path []string) []pathSegment {
+	segments := make([]pathSegment, len(path))
+	for i, segment := range path {
+		segments[i] = pathSegment{[]byte(segment), parseIndex([]byte(segment))}
+	}
+	return segments
+}
+
+func (r Ref) overflow(t reflect.Type, format string, args ...interface{}) error {
+	return r.newError(ErrOverflow, r.index_0, "", format+" overflows Go value of type %s", append(args, t.String())...)
+}
+
+//stores the value in v, which has one of the kinds of Scalar, converting it like Get
+func (r Ref) setScalar(v reflect.Value) error {
+	if !v.CanAddr() {
+		x := reflect.New(v.Type()).Elem()
+		if err := r.setScalar(x); err != nil {
+			return err
+		}
+		v.Set(x)
+		return nil
+	}
+	return r.getScalar(v.Addr().UnsafePointer(), v.Type())
+}
+
+//stores the value at p, which points to a value of type t. t has one of the kinds of Scalar
+func (r Ref) getScalar(p unsafe.Pointer, t reflect.Type) error {
+	var err error
+	switch t.Kind() {
+	case reflect.Int:
+		*(*int)(p), err = getInt[int](r, t)
+	case reflect.Int8:
+		*(*int8)(p), err = getInt[int8](r, t)
+	case reflect.Int16:
+		*(*int16)(p), err = getInt[int16](r, t)
+	case reflect.Int32:
+		*(*int32)(p), err = getInt[int32](r, t)
+	case reflect.Int64:
+		*(*int64)(p), err = getInt[int64](r, t)
+	case reflect.Uint:
+		*(*uint)(p), err = getInt[uint](r, t)
+	case reflect.Uint8:
+		*(*uint8)(p), err = getInt[uint8](r, t)
+	case reflect.Uint16:
+		*(*uint16)(p), err = getInt[uint16](r, t)
+	case reflect.Uint32:
+		*(*uint32)(p), err = getInt[uint32](r, t)
+	case reflect.Uint64:
+		*(*uint64)(p), err = getInt[uint64](r, t)
+	case reflect.Uintptr:
+		*(*uintptr)(p), err = getInt[uintptr](r, t)
+	case reflect.Float32:
+		*(*float32)(p), err = getFloat[float32](r, t)
+	case reflect.Float64:
+		*(*float64)(p), err = getFloat[float64](r, t)
+	case reflect.Bool:
+		*(*bool)(p), err = r.Bool()
+	case reflect.String:
+		*(*string)(p), err = r.String()
+	default:
+		return r.mismatch("unsupported Go type %s", t.String())
+	}
+	return err
+}
+
+//reads an INT, UINT or their indirect forms into an integer type. The value is converted to T and back, so that
+//values out of the range of T are reported instead of being truncated
+func getInt[T Integer](r Ref, t reflect.Type) (T, error) {
+	switch vType := r.context.ItemVarType(); vType {
+	case INT, INDIRECT_INT:
+		i, err := r.Int()
+		if err != nil {
+			return 0, err
+		}
+		x := T(i)
+		if int64(x) != i || (x < 0) != (i < 0) {
+			return 0, r.overflow(t, "value %d", i)
+		}
+		return x, nil
+	case UINT, INDIRECT_UINT:
+		u, err := r.Uint()
+		if err != nil {
+			return 0, err
+		}
+		x := T(u)
+		if uint64(x) != u || x < 0 {
+			return 0, r.overflow(t, "value %d", u)
+		}
+		return x, nil
+	default:
+		return 0, r.mismatch("object of type %s can not be converted to %s", vType.toString(), t.String())
+	}
+}
+
+//reads a FLOAT, INT, UINT or their indirect forms into a float type. Infinities and NaN are kept, other values have to
+//be represented exactly by T
+func getFloat[T Float](r Ref, t reflect.Type) (T, error) {
+	switch vType := r.context.ItemVarType(); vType {
+	case FLOAT, INDIRECT_FLOAT:
+		f, err := r.Float()
+		if err != nil {
+			return 0, err
+		}
+		x := T(f)
+		if float64(x) != f && !math.IsNaN(f) {
+			return 0, r.overflow(t, "value %g", f)
+		}
+		return x, nil
+	case INT, INDIRECT_INT:
+		i, err := r.Int()
+		if err != nil {
+			return 0, err
+		}
+		//float64(x) is an integer, but converting it back to int64 is only defined below 2^63
+		x := T(i)
+		if float64(x) >= math.MaxInt64 || int64(x) != i {
+			return 0, r.overflow(t, "value %d", i)
+		}
+		return x, nil
+	case UINT, INDIRECT_UINT:
+		u, err := r.Uint()
+		if err != nil {
+			return 0, err
+		}
+		x := T(u)
+		if float64(x) >= math.MaxUint64 || uint64(x) != u {
+			return 0, r.overflow(t, "value %d", u)
+		}
+		return x, nil
+	default:
+		return 0, r.mismatch("object of type %s can not be converted to %s", vType.toString(), t.String())
+	}
+}
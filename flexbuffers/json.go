@@ -0,0 +1,329 @@
+package flexbuffers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//FromJSON converts a single JSON value into a flexbuffer, the same way `flatc --binary --flexbuffers` does: objects
+//become maps, arrays become untyped vectors, numbers without a fraction or exponent become INT (or UINT when they only
+//fit an uint64) and all other numbers become FLOAT. Strings and keys are shared. The input is read token by token,
+//without decoding it into Go values first
+func FromJSON(r io.Reader) ([]byte, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	b := NewBuilderWithOptions(BuilderOptions{ShareStrings: true, ShareKeys: true})
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err := b.buildJSON(dec, nil, tok); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("unexpected data after top-level JSON value")
+		}
+		return nil, err
+	}
+	var buff []byte
+	if _, err := b.SerializeBuffer(&buff); err != nil {
+		return nil, err
+	}
+	return buff, nil
+}
+
+//adds the JSON value starting with tok to the builder, under the key k if the value is part of an object
+func (b *Builder) buildJSON(dec *json.Decoder, k *key, tok json.Token) error {
+	switch x := tok.(type) {
+	case nil:
+		return b.registerElementWithOptionalKey(k, newNULL())
+	case bool:
+		return b.registerElementWithOptionalKey(k, newBOOL(x))
+	case string:
+		return b.addShareable(k, b.arena.newFlexString(x))
+	case json.Number:
+		e, err := b.jsonNumber(x)
+		if err != nil {
+			return err
+		}
+		return b.registerElementWithOptionalKey(k, e)
+	case json.Delim:
+		switch x {
+		case '[':
+			if err := b.startWithOptionalKey(k, b.arena.newVector()); err != nil {
+				return err
+			}
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := b.buildJSON(dec, nil, tok); err != nil {
+					return err
+				}
+			}
+		case '{':
+			if err := b.startWithOptionalKey(k, b.arena.newFlexMap()); err != nil {
+				return err
+			}
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				name, ok := tok.(string)
+				if !ok {
+					return fmt.Errorf("expected an object key, got %v", tok)
+				}
+				if tok, err = dec.Token(); err != nil {
+					return err
+				}
+				if err := b.buildJSON(dec, b.arena.newKey(name), tok); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unexpected JSON delimiter %s", x)
+		}
+		//consume the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		b.End()
+		return nil
+	}
+	return fmt.Errorf("unexpected JSON token %v of type %T", tok, tok)
+}
+
+func (b *Builder) jsonNumber(n json.Number) (element, error) {
+	s := n.String()
+	if strings.ContainsAny(s, ".eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return element{}, fmt.Errorf("unable to convert JSON number %s to float: %w", s, err)
+		}
+		return b.newFLOAT(f), nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return newINT(i), nil
+	}
+	u, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return element{}, fmt.Errorf("JSON integer %s does not fit in 64 bits", s)
+	}
+	return newUINT(u), nil
+}
+
+//Options for Ref.WriteJSONWithOptions
+type JSONOptions struct {
+	UnquotedKeys bool //keys that look like identifiers are written without quotes, as flatc does without --strict-json
+}
+
+//WriteJSON writes the value as JSON, formatted the same way as `flatc --flexbuffers --json --strict-json`:
+//integers are written as such (UINT values above 2^53 keep all their digits), floats with up to 12 decimal places
+//and at least one, blobs as strings with every byte that is not part of a valid UTF-8 sequence escaped as \xNN
+func (r Ref) WriteJSON(w io.Writer) error {
+	return r.WriteJSONWithOptions(w, JSONOptions{})
+}
+
+//WriteJSONWithOptions writes the value as JSON, see WriteJSON
+func (r Ref) WriteJSONWithOptions(w io.Writer, opts JSONOptions) error {
+	buff, err := r.appendJSON(nil, opts, 0)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buff)
+	return err
+}
+
+func (r Ref) appendJSON(buff []byte, opts JSONOptions, depth int) ([]byte, error) {
+	if err := r.checkDepth(depth); err != nil {
+		return buff, err
+	}
+	vType := r.context.ItemVarType()
+	switch true {
+	case vType == NULL:
+		return append(buff, "null"...), nil
+	case vType == BOOL:
+		l, err := r.Bool()
+		if err != nil {
+			return buff, err
+		}
+		return strconv.AppendBool(buff, l), nil
+	case vType == INT, vType == INDIRECT_INT:
+		i, err := r.Int()
+		if err != nil {
+			return buff, err
+		}
+		return strconv.AppendInt(buff, i, 10), nil
+	case vType == UINT, vType == INDIRECT_UINT:
+		u, err := r.Uint()
+		if err != nil {
+			return buff, err
+		}
+		return strconv.AppendUint(buff, u, 10), nil
+	case vType == FLOAT, vType == INDIRECT_FLOAT:
+		f, err := r.Float()
+		if err != nil {
+			return buff, err
+		}
+		return appendJSONFloat(buff, f), nil
+	case vType == KEY:
+		k, err := r.getBytes(r.index_0, r.item_count)
+		if err != nil {
+			return buff, err
+		}
+		if opts.UnquotedKeys {
+			return append(buff, k...), nil
+		}
+		return appendJSONString(buff, k), nil
+	case isBlobLike(vType):
+		s, err := r.getBytes(r.index_0, r.item_count)
+		if err != nil {
+			return buff, err
+		}
+		return appendJSONString(buff, s), nil
+	case vType == MAP:
+		keys, err := r.KeyVector()
+		if err != nil {
+			return buff, err
+		}
+		buff = append(buff, "{ "...)
+		for i := int64(0); i < int64(r.item_count); i++ {
+			if i > 0 {
+				buff = append(buff, ", "...)
+			}
+			k, err := keys.getItemAsRef(i, Pack(KEY, b8))
+			if err != nil {
+				return buff, err
+			}
+			name, err := k.getBytes(k.index_0, k.item_count)
+			if err != nil {
+				return buff, err
+			}
+			if opts.UnquotedKeys && isIdentifier(name) {
+				buff = append(buff, name...)
+			} else {
+				buff = appendJSONString(buff, name)
+			}
+			buff = append(buff, ": "...)
+			val_ref, err := r.Index(i)
+			if err != nil {
+				return buff, err
+			}
+			if buff, err = val_ref.appendJSON(buff, opts, depth+1); err != nil {
+				return buff, atSegment(err, keySegment(string(name)))
+			}
+		}
+		return append(buff, " }"...), nil
+	case isVector(vType):
+		buff = append(buff, "[ "...)
+		for i := int64(0); i < int64(r.item_count); i++ {
+			if i > 0 {
+				buff = append(buff, ", "...)
+			}
+			item_ref, err := r.Index(i)
+			if err != nil {
+				return buff, err
+			}
+			if buff, err = item_ref.appendJSON(buff, opts, depth+1); err != nil {
+				return buff, atSegment(err, indexSegment(i))
+			}
+		}
+		return append(buff, " ]"...), nil
+	}
+	return buff, r.corrupt(r.index_0, "unknown type %s", vType.toString())
+}
+
+//formats like flatbuffers::FloatToString with a precision of 12: fixed notation without trailing zeros
+func appendJSONFloat(buff []byte, f float64) []byte {
+	switch true {
+	case math.IsNaN(f):
+		return append(buff, "nan"...)
+	case math.IsInf(f, 1):
+		return append(buff, "inf"...)
+	case math.IsInf(f, -1):
+		return append(buff, "-inf"...)
+	}
+	buff = strconv.AppendFloat(buff, f, 'f', 12, 64)
+	end := len(buff)
+	for buff[end-1] == '0' {
+		end--
+	}
+	if buff[end-1] == '.' {
+		end++ //keep one zero for whole numbers
+	}
+	return buff[:end]
+}
+
+//escapes like flatbuffers::EscapeString: printable ASCII is written as is, other valid UTF-8 sequences as \uXXXX
+//(surrogate pairs above the BMP) and bytes that are not valid UTF-8 as \xNN
+func appendJSONString(buff []byte, s []byte) []byte {
+	const hex = "0123456789ABCDEF"
+	buff = append(buff, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case '\n':
+			buff = append(buff, `\n`...)
+		case '\t':
+			buff = append(buff, `\t`...)
+		case '\r':
+			buff = append(buff, `\r`...)
+		case '\b':
+			buff = append(buff, `\b`...)
+		case '\f':
+			buff = append(buff, `\f`...)
+		case '"':
+			buff = append(buff, `\"`...)
+		case '\\':
+			buff = append(buff, `\\`...)
+		default:
+			if c >= ' ' && c <= '~' {
+				buff = append(buff, c)
+				break
+			}
+			rn, size := utf8.DecodeRune(s[i:])
+			if rn == utf8.RuneError && size <= 1 {
+				buff = append(buff, '\\', 'x', hex[c>>4], hex[c&0xF])
+				break
+			}
+			if rn > 0xFFFF {
+				base := rn - 0x10000
+				high, low := (base>>10)+0xD800, (base&0x3FF)+0xDC00
+				buff = append(buff, '\\', 'u', hex[high>>12], hex[high>>8&0xF], hex[high>>4&0xF], hex[high&0xF])
+				buff = append(buff, '\\', 'u', hex[low>>12], hex[low>>8&0xF], hex[low>>4&0xF], hex[low&0xF])
+			} else {
+				buff = append(buff, '\\', 'u', hex[rn>>12], hex[rn>>8&0xF], hex[rn>>4&0xF], hex[rn&0xF])
+			}
+			i += size
+			continue
+		}
+		i++
+	}
+	return append(buff, '"')
+}
+
+//keys are only written without quotes if they start with a letter or underscore and consist of letters, digits and
+//underscores only
+func isIdentifier(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i, c := range name {
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func toJSON(t *testing.T, buff []byte, opts JSONOptions) string {
+	var out bytes.Buffer
+	require.NoError(t, NewRef(buff).WriteJSONWithOptions(&out, opts))
+	return out.String()
+}
+
+func TestFromJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"int", `-42`, `-42`},
+		{"whole float", `1.0`, `1.0`},
+		{"float", `0.1`, `0.1`},
+		{"exponent", `25e-1`, `2.5`},
+		{"int64 max", `9223372036854775807`, `9223372036854775807`},
+		{"uint64 max", `18446744073709551615`, `18446744073709551615`},
+		{"above 2^53", `9007199254740993`, `9007199254740993`},
+		{"bool", `true`, `true`},
+		{"null", `null`, `null`},
+		{"string", `"a\"b\né😀"`, `"a\"b\n\u00E9\uD83D\uDE00"`},
+		{"empty vector", `[]`, `[  ]`},
+		{"empty map", `{}`, `{  }`},
+		{"vector", `[1, "x", 2.5, false, null]`, `[ 1, "x", 2.5, false, null ]`},
+		{"map", `{"b": [1, {"c": "d"}], "a": 1, "$x": 0}`, `{ "$x": 0, "a": 1, "b": [ 1, { "c": "d" } ] }`},
+		{"empty key", `{"a": {"": 2}, "": 1}`, `{ "": 1, "a": { "": 2 } }`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buff, err := FromJSON(strings.NewReader(tt.in))
+			require.NoError(t, err)
+			require.Equal(t, tt.out, toJSON(t, buff, JSONOptions{}))
+		})
+	}
+
+	//integers are INT unless they only fit an uint64, numbers with a fraction or an exponent are FLOAT
+	buff, err := FromJSON(strings.NewReader(`[1, 18446744073709551615, 1.0, 1e2]`))
+	require.NoError(t, err)
+	var out []interface{}
+	require.NoError(t, Unmarshal(buff, &out))
+	require.Equal(t, []interface{}{int64(1), uint64(18446744073709551615), 1.0, 100.0}, out)
+
+	//strings and keys are shared, like in flatc
+	buff, err = FromJSON(strings.NewReader(`[{"k": "v"}, {"k": "v"}]`))
+	require.NoError(t, err)
+	b := NewBuilderWithOptions(BuilderOptions{ShareStrings: true, ShareKeys: true})
+	require.NoError(t, b.AutoBuild([]map[string]string{{"k": "v"}, {"k": "v"}}))
+	expected, err := b.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, expected, buff)
+
+	//empty keys are allowed and can be looked up
+	buff, err = FromJSON(strings.NewReader(`{"a": 2, "": 1}`))
+	require.NoError(t, err)
+	val, err := NewRef(buff).MapIndex("")
+	require.NoError(t, err)
+	i, err := val.Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), i)
+
+	for _, in := range []string{``, `[1,`, `{"a" 1}`, `1 2`, `18446744073709551616`, `{"a": 1, "a": 2}`} {
+		_, err := FromJSON(strings.NewReader(in))
+		require.Error(t, err, in)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	b := NewBuilder()
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.StartBlobWithKey("blob", []byte{'a', 0, 0xff, 0xc3, 0xa9, '"'}))
+	b.End()
+	require.NoError(t, b.StartUintTripleWithKey("triple"))
+	require.NoError(t, b.Uint(1))
+	require.NoError(t, b.Uint(2))
+	require.NoError(t, b.Uint(3))
+	b.End()
+	require.NoError(t, b.StartIntScalarWithKey("indirect"))
+	require.NoError(t, b.Int(-7))
+	b.End()
+	require.NoError(t, b.FloatWithKey("f32", 0.1))
+	require.NoError(t, b.FloatWithKey("big", 340282346638528859811704183484516925440.0))
+	require.NoError(t, b.StartVectorWithKey("not an identifier"))
+	require.NoError(t, b.Key("k"))
+	b.End()
+	b.End()
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+
+	require.Equal(t, `{ "big": 340282346638528859811704183484516925440.0, "blob": "a\u0000\xFF\u00E9\"", `+
+		`"f32": 0.1, "indirect": -7, "not an identifier": [ "k" ], "triple": [ 1, 2, 3 ] }`, toJSON(t, buff, JSONOptions{}))
+	require.Equal(t, `{ big: 340282346638528859811704183484516925440.0, blob: "a\u0000\xFF\u00E9\"", `+
+		`f32: 0.1, indirect: -7, "not an identifier": [ k ], triple: [ 1, 2, 3 ] }`, toJSON(t, buff, JSONOptions{UnquotedKeys: true}))
+
+	//float32 values are widened before they are formatted
+	require.Equal(t, `[ 0.10000000149 ]`, toJSON(t, mustMarshal(t, []float32{0.1}), JSONOptions{}))
+	require.Equal(t, `[ true, false ]`, toJSON(t, mustMarshal(t, []bool{true, false}), JSONOptions{}))
+
+	//the strict output can be read back
+	in := `{ "a": [ 1, -2, 3.5, "x" ], "b": { "c": null, "d": 18446744073709551615 } }`
+	buff, err = FromJSON(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Equal(t, in, toJSON(t, buff, JSONOptions{}))
+
+	//unknown types are reported instead of being written
+	var out bytes.Buffer
+	err = Ref{buffer: []byte{0}, context: context(40 << 2)}.WriteJSON(&out)
+	require.ErrorIs(t, err, ErrCorrupt)
+}
@@ -0,0 +1,189 @@
+package flexbuffers
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+//Unescaped segments of a JSON pointer up to this length are looked up without allocating
+const maxStackSegment = 256
+
+//Lookup returns the value the JSON pointer (RFC 6901) leads to from r, e.g. "/config/servers/2/host". Segments are
+//keys of maps or decimal indices of vectors, "~1" stands for "/" and "~0" for "~" in keys. The empty pointer refers to
+//r itself. Keys are found with a binary search on the key vectors and nothing is allocated unless the lookup fails.
+//Failures are reported with a *RefError naming the segment that could not be followed, malformed pointers with a
+//*RefError wrapping ErrInvalidPointer
+func (r Ref) Lookup(pointer string) (Ref, error) {
+	if pointer != "" && pointer[0] != '/' {
+		return Ref{}, invalidPointer(pointer, "it must be empty or start with /")
+	}
+	var scratch [maxStackSegment]byte
+	from := r
+	for end := 0; end < len(pointer); {
+		start := end + 1
+		end = strings.IndexByte(pointer[start:], '/')
+		if end < 0 {
+			end = len(pointer)
+		} else {
+			end += start
+		}
+		segment := pointer[start:end]
+		key := *(*[]byte)(unsafe.Pointer(&segment))
+		if strings.IndexByte(segment, '~') >= 0 {
+			var ok bool
+			if key, ok = unescapeSegment(scratch[:0], segment); !ok {
+				return Ref{}, invalidPointer(pointer, "~ must be followed by 0 or 1 in segment %q", segment)
+			}
+		}
+		var err error
+		if r, err = r.step(key, parseIndex(key)); err != nil {
+			followed, _ := CompilePath(pointer[:start-1])
+			return Ref{}, inSegment(err, from, followed.segments, pointer, end)
+		}
+	}
+	return r, nil
+}
+
+//A Path is a compiled JSON pointer, that can be looked up repeatedly without being parsed again
+type Path struct {
+	pointer  string
+	segments []pathSegment
+	ends     []int //end of every segment in pointer
+}
+
+type pathSegment struct {
+	key   []byte //unescaped segment
+	index int64  //segment as a vector index, -1 if it is not one
+}
+
+//CompilePath parses a JSON pointer (RFC 6901), see Ref.Lookup
+func CompilePath(pointer string) (Path, error) {
+	if pointer != "" && pointer[0] != '/' {
+		return Path{}, invalidPointer(pointer, "it must be empty or start with /")
+	}
+	p := Path{pointer: pointer}
+	for end := 0; end < len(pointer); {
+		start := end + 1
+		end = strings.IndexByte(pointer[start:], '/')
+		if end < 0 {
+			end = len(pointer)
+		} else {
+			end += start
+		}
+		key, ok := unescapeSegment(nil, pointer[start:end])
+		if !ok {
+			return Path{}, invalidPointer(pointer, "~ must be followed by 0 or 1 in segment %q", pointer[start:end])
+		}
+		p.segments = append(p.segments, pathSegment{key, parseIndex(key)})
+		p.ends = append(p.ends, end)
+	}
+	return p, nil
+}
+
+//String returns the JSON pointer the path has been compiled from
+func (p Path) String() string {
+	return p.pointer
+}
+
+//Lookup returns the value the path leads to from r, like Ref.Lookup
+func (p Path) Lookup(r Ref) (Ref, error) {
+	from := r
+	for i, segment := range p.segments {
+		var err error
+		if r, err = r.step(segment.key, segment.index); err != nil {
+			return Ref{}, inSegment(err, from, p.segments[:i], p.pointer, p.ends[i])
+		}
+	}
+	return r, nil
+}
+
+//appends the segment to dst, after replacing ~1 with / and ~0 with ~. Reports false if a ~ is not followed by 0 or 1
+func unescapeSegment(dst []byte, segment string) ([]byte, bool) {
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if c == '~' {
+			if i+1 == len(segment) || segment[i+1] != '0' && segment[i+1] != '1' {
+				return nil, false
+			}
+			c = '~'
+			if segment[i+1] == '1' {
+				c = '/'
+			}
+			i++
+		}
+		dst = append(dst, c)
+	}
+	return dst, true
+}
+
+//reports a malformed JSON pointer
+func invalidPointer(pointer string, format string, args ...interface{}) error {
+	return &RefError{Err: ErrInvalidPointer, msg: fmt.Sprintf("%q: "+format, append([]interface{}{pointer}, args...)...)}
+}
+
+//parses a vector index: 0 or a decimal number without leading zeros. Returns -1 otherwise, e.g. for "-" which stands
+//for the item past the end of a vector
+func parseIndex(segment []byte) int64 {
+	if len(segment) == 0 || len(segment) > 1 && segment[0] == '0' || len(segment) > 18 {
+		return -1
+	}
+	var i int64
+	for _, c := range segment {
+		if c < '0' || c > '9' {
+			return -1
+		}
+		i = i*10 + int64(c-'0')
+	}
+	return i
+}
+
+//follows a single segment: a key of a map, or the index of an item of a vector
+func (r Ref) step(key []byte, index int64) (Ref, error) {
+	vType := r.context.ItemVarType()
+	switch true {
+	case r.IsMap():
+		i, err := r.findKey(key)
+		if err != nil {
+			return Ref{}, err
+		}
+		if i < 0 {
+			return Ref{}, r.keyNotFound(string(key))
+		}
+		return r.Index(i)
+	case r.IsVector() && !isScalar(vType):
+		if index < 0 {
+			return Ref{}, r.newError(ErrOutOfBounds, r.index_0, keySegment(string(key)), "%q is not an index of a vector of %d item(s)", string(key), r.item_count)
+		}
+		return r.Index(index)
+	}
+	return Ref{}, r.newError(ErrTypeMismatch, r.index_0, keySegment(string(key)), "object of type %s can not be looked up with %q", vType.toString(), string(key))
+}
+
+//adds the segments that have been followed from r before the failed one to the path of a *RefError, and the failed
+//segment of the JSON pointer to its message
+func inSegment(err error, r Ref, followed []pathSegment, pointer string, end int) error {
+	e, ok := err.(*RefError)
+	if !ok {
+		return err
+	}
+	e.msg = fmt.Sprintf("%s (JSON pointer %q failed at %q)", e.msg, pointer, pointer[:end])
+	return atSegment(e, r.followedPath(followed))
+}
+
+//returns the path of segments that have been followed from r successfully, e.g. .servers[1]
+func (r Ref) followedPath(segments []pathSegment) string {
+	path := ""
+	for _, segment := range segments {
+		if r.IsMap() {
+			path += keySegment(string(segment.key))
+		} else {
+			path += indexSegment(segment.index)
+		}
+		var err error
+		if r, err = r.step(segment.key, segment.index); err != nil {
+			break
+		}
+	}
+	return path
+}
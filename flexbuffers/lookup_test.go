@@ -0,0 +1,97 @@
+package flexbuffers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	buff, err := FromJSON(strings.NewReader(`{
+		"config": {
+			"name": "prod",
+			"servers": [
+				{ "host": "a", "port": 1 },
+				{ "host": "b", "port": 2 },
+				{ "host": "c", "port": 3, "a/b": "slash", "m~n": "tilde" }
+			]
+		},
+		"version": 2
+	}`))
+	require.NoError(t, err)
+	root, err := Root(buff)
+	require.NoError(t, err)
+
+	lookupString := func(pointer string) string {
+		r, err := root.Lookup(pointer)
+		require.NoError(t, err, pointer)
+		s, err := r.String()
+		require.NoError(t, err, pointer)
+		p, err := CompilePath(pointer)
+		require.NoError(t, err, pointer)
+		require.Equal(t, pointer, p.String())
+		compiled, err := p.Lookup(root)
+		require.NoError(t, err, pointer)
+		require.Equal(t, r, compiled, pointer)
+		return s
+	}
+	require.Equal(t, "prod", lookupString("/config/name"))
+	require.Equal(t, "b", lookupString("/config/servers/1/host"))
+	require.Equal(t, "c", lookupString("/config/servers/2/host"))
+	require.Equal(t, "slash", lookupString("/config/servers/2/a~1b"))
+	require.Equal(t, "tilde", lookupString("/config/servers/2/m~0n"))
+
+	r, err := root.Lookup("")
+	require.NoError(t, err)
+	require.Equal(t, root, r)
+
+	for pointer, sentinel := range map[string]error{
+		"/config/servers/3/host":  ErrOutOfBounds,
+		"/config/servers/-":       ErrOutOfBounds,
+		"/config/servers/01":      ErrOutOfBounds,
+		"/config/servers/x":       ErrOutOfBounds,
+		"/config/hosts":           ErrKeyNotFound,
+		"/config/name/0":          ErrTypeMismatch,
+		"/version/x":              ErrTypeMismatch,
+		"/config/servers/0/host/": ErrTypeMismatch,
+		"/config/servers/0/":      ErrKeyNotFound,
+	} {
+		_, err := root.Lookup(pointer)
+		require.ErrorIs(t, err, sentinel, pointer)
+		p, err := CompilePath(pointer)
+		require.NoError(t, err, pointer)
+		_, err = p.Lookup(root)
+		require.ErrorIs(t, err, sentinel, pointer)
+	}
+
+	//errors name the segment that could not be followed
+	_, err = root.Lookup("/config/servers/1/user/name")
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	var refErr *RefError
+	require.ErrorAs(t, err, &refErr)
+	require.Equal(t, "$.config.servers[1].user", refErr.Path())
+	require.Contains(t, err.Error(), `failed at "/config/servers/1/user"`)
+
+	for _, pointer := range []string{"config", "/a~2", "/a~"} {
+		_, err := root.Lookup(pointer)
+		require.ErrorIs(t, err, ErrInvalidPointer, pointer)
+		_, err = CompilePath(pointer)
+		require.ErrorIs(t, err, ErrInvalidPointer, pointer)
+	}
+	_, err = root.Lookup("/a~2")
+	require.EqualError(t, err, `flexbuffers: invalid JSON pointer "/a~2": ~ must be followed by 0 or 1 in segment "a~2"`)
+
+	//successful lookups do not allocate
+	p, err := CompilePath("/config/servers/2/m~0n")
+	require.NoError(t, err)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := root.Lookup("/config/servers/2/m~0n"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Lookup(root); err != nil {
+			t.Fatal(err)
+		}
+	})
+	require.Zero(t, allocs)
+}
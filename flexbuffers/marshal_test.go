@@ -0,0 +1,792 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type Celsius float32
+
+type Address struct {
+	Street string `flexbuffers:"street"`
+	Zip    string `flexbuffers:"zip,omitempty"`
+}
+
+type Audited struct {
+	CreatedBy string `flexbuffers:"created_by"`
+}
+
+type Person struct {
+	Audited
+	Name      string             `flexbuffers:"name"`
+	Age       uint8              `flexbuffers:"age"`
+	Temp      Celsius            `flexbuffers:"temp"`
+	Home      *Address           `flexbuffers:"home"`
+	Work      *Address           `flexbuffers:"work"`
+	Previous  []Address          `flexbuffers:"previous,omitempty"`
+	Scores    map[string]float64 `flexbuffers:"scores"`
+	Nicknames []string           `flexbuffers:"nicknames,omitempty"`
+	secret    string
+	Skipped   int `flexbuffers:"-"`
+}
+
+func TestMarshalLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       interface{}
+		expected []byte
+	}{
+		{"string", "hi", []byte{0x02, 'h', 'i', 0x00, 0x03, 0x14, 0x01}},
+		//the vector is aligned to the 4 byte width of the float and the string offset counts from its own position
+		{"mixed vector", []interface{}{1, "a", 2.5}, []byte{
+			0x01, 'a', 0x00, 0x00,
+			0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x40,
+			0x06, 0x14, 0x0e, 0x0f, 0x2a, 0x01}},
+		//fixed typed vectors have no size prefix
+		{"triple", [3]int32{1, 2, 3}, []byte{0x01, 0x02, 0x03, 0x03, 0x4c, 0x01}},
+		//keys are serialized in insertion order, interleaved with the values
+		{"map", map[string]interface{}{"b": 1, "a": "x", "c": []interface{}{1, 2}}, []byte{
+			'a', 0x00, 0x01, 'x', 0x00, 'b', 0x00, 'c', 0x00, 0x02, 0x01, 0x02, 0x04, 0x04,
+			0x03, 0x0f, 0x0b, 0x0a,
+			0x03, 0x01, 0x03, 0x12, 0x01, 0x0d, 0x14, 0x04, 0x28,
+			0x06, 0x24, 0x01}},
+		//a float32 is widened to float64 when it shares a vector with a float64
+		{"widened float", []interface{}{1.5, 1e300}, []byte{
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x3f,
+			0x9c, 0x75, 0x00, 0x88, 0x3c, 0xe4, 0x37, 0x7e,
+			0x0f, 0x0f, 0x12, 0x2b, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buff, err := Marshal(tt.in)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, buff)
+		})
+	}
+}
+
+func TestMarshalWideOffsets(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	words := []interface{}{}
+	for i := 0; i < 200; i++ {
+		words = append(words, long[:i])
+	}
+	in := map[string]interface{}{"long": long, "words": words, "big": uint64(math.MaxUint64), "neg": math.MinInt64}
+	buff, err := Marshal(in)
+	require.NoError(t, err)
+	var out map[string]interface{}
+	require.NoError(t, Unmarshal(buff, &out))
+	require.Equal(t, map[string]interface{}{"long": long, "words": words, "big": uint64(math.MaxUint64), "neg": int64(math.MinInt64)}, out)
+}
+
+func TestMarshalStructs(t *testing.T) {
+	in := Person{
+		Audited:   Audited{CreatedBy: "admin"},
+		Name:      "Joe",
+		Age:       42,
+		Temp:      36.5,
+		Home:      &Address{Street: "Main St", Zip: "00-001"},
+		Previous:  []Address{{Street: "First"}, {Street: "Second", Zip: "2"}},
+		Scores:    map[string]float64{"math": 5, "art": 3.25},
+		secret:    "not encoded",
+		Skipped:   1,
+		Nicknames: nil,
+	}
+	buff, err := Marshal(in)
+	require.NoError(t, err)
+
+	var generic map[string]interface{}
+	require.NoError(t, Unmarshal(buff, &generic))
+	require.Equal(t, map[string]interface{}{
+		"created_by": "admin",
+		"name":       "Joe",
+		"age":        uint64(42),
+		"temp":       36.5,
+		"home":       map[string]interface{}{"street": "Main St", "zip": "00-001"},
+		"work":       nil,
+		"previous":   []interface{}{map[string]interface{}{"street": "First"}, map[string]interface{}{"street": "Second", "zip": "2"}},
+		"scores":     map[string]interface{}{"math": 5.0, "art": 3.25},
+	}, generic)
+
+	var out Person
+	require.NoError(t, Unmarshal(buff, &out))
+	in.secret, in.Skipped = "", 0
+	require.Equal(t, in, out)
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	_, err := Marshal(map[int]string{1: "a"})
+	require.Error(t, err)
+	_, err = Marshal(make(chan int))
+	require.Error(t, err)
+	_, err = Marshal(struct{ F func() }{})
+	require.Error(t, err)
+
+	//cycles are reported instead of overflowing the stack
+	type node struct {
+		Next *node
+	}
+	n := &node{}
+	n.Next = n
+	_, err = Marshal(n)
+	var unsupported *UnsupportedValueError
+	require.ErrorAs(t, err, &unsupported)
+	m := map[string]interface{}{}
+	m["m"] = m
+	_, err = Marshal(m)
+	require.ErrorAs(t, err, &unsupported)
+	v := []interface{}{nil}
+	v[0] = v
+	_, err = Marshal(v)
+	require.ErrorAs(t, err, &unsupported)
+	deep := &node{}
+	for i := 0; i < 2*startDetectingCyclesAfter; i++ {
+		deep = &node{Next: deep}
+	}
+	_, err = Marshal(deep)
+	require.NoError(t, err)
+}
+
+type embeddedA struct {
+	X int
+	Y int
+	Z int `flexbuffers:"Z"`
+}
+
+type embeddedB struct {
+	X int
+	Y int `flexbuffers:"Y"`
+	Z int `flexbuffers:"Z"`
+}
+
+func TestMarshalAmbiguousFields(t *testing.T) {
+	//equally nested fields with the same name are dropped, unless exactly one of them is tagged
+	in := struct {
+		embeddedA
+		embeddedB
+	}{embeddedA{1, 2, 3}, embeddedB{4, 5, 6}}
+	buff, err := Marshal(in)
+	require.NoError(t, err)
+	var generic map[string]interface{}
+	require.NoError(t, Unmarshal(buff, &generic))
+	require.Equal(t, map[string]interface{}{"Y": int64(5)}, generic)
+
+	//a less nested field wins
+	outer := struct {
+		embeddedA
+		embeddedB
+		X string
+	}{embeddedA{1, 2, 3}, embeddedB{4, 5, 6}, "x"}
+	buff, err = Marshal(outer)
+	require.NoError(t, err)
+	require.NoError(t, Unmarshal(buff, &generic))
+	require.Equal(t, map[string]interface{}{"X": "x", "Y": int64(5)}, generic)
+	outer.X, outer.embeddedB.Y = "", 0
+	require.NoError(t, Unmarshal(buff, &outer))
+	require.Equal(t, "x", outer.X)
+	require.Equal(t, 5, outer.embeddedB.Y)
+	require.Equal(t, 2, outer.embeddedA.Y)
+
+	//a type embedded twice at the same depth is expanded for both, so all of its fields are ambiguous
+	twice := struct {
+		wrappedA
+		wrappedA2
+	}{wrappedA{embeddedA{1, 2, 3}}, wrappedA2{embeddedA{4, 5, 6}}}
+	buff, err = Marshal(twice)
+	require.NoError(t, err)
+	generic = nil
+	require.NoError(t, Unmarshal(buff, &generic))
+	require.Equal(t, map[string]interface{}{}, generic)
+}
+
+type wrappedA struct{ embeddedA }
+
+type wrappedA2 struct{ embeddedA }
+
+//types embedding themselves are flattened once, at the shallowest depth they are embedded at
+type recursiveEmbed struct {
+	*recursiveEmbed
+	X int
+}
+
+type recursivePair struct {
+	*recursiveEmbed
+	embeddedA
+}
+
+func TestMarshalRecursiveEmbedding(t *testing.T) {
+	buff, err := Marshal(recursiveEmbed{X: 1})
+	require.NoError(t, err)
+	var generic map[string]interface{}
+	require.NoError(t, Unmarshal(buff, &generic))
+	require.Equal(t, map[string]interface{}{"X": int64(1)}, generic)
+
+	//X is embedded at the same depth through both structs and dropped, Y and Z only come from embeddedA
+	buff, err = Marshal(recursivePair{&recursiveEmbed{X: 1}, embeddedA{2, 3, 4}})
+	require.NoError(t, err)
+	generic = nil
+	require.NoError(t, Unmarshal(buff, &generic))
+	require.Equal(t, map[string]interface{}{"Y": int64(3), "Z": int64(4)}, generic)
+}
+
+//Money is stored as a (units, cents) int tuple
+type Money struct {
+	Units int64
+	Cents int8
+}
+
+func (m Money) MarshalFlexBuffer(b *Builder) error {
+	if m.Cents < 0 || m.Cents > 99 {
+		return fmt.Errorf("invalid amount of cents %d", m.Cents)
+	}
+	if err := b.StartIntTuple(); err != nil {
+		return err
+	}
+	if err := b.Int(m.Units); err != nil {
+		return err
+	}
+	if err := b.Int(int64(m.Cents)); err != nil {
+		return err
+	}
+	b.End()
+	return nil
+}
+
+func (m *Money) UnmarshalFlexBuffer(r Ref) error {
+	ints, err := r.IntSlice()
+	if err != nil {
+		return err
+	}
+	if len(ints) != 2 {
+		return fmt.Errorf("expected 2 ints, got %d", len(ints))
+	}
+	m.Units, m.Cents = ints[0], int8(ints[1])
+	return nil
+}
+
+//Level is stored by name
+type Level int
+
+func (l *Level) MarshalFlexBuffer(b *Builder) error {
+	return b.String([]string{"low", "high"}[*l])
+}
+
+func (l *Level) UnmarshalFlexBuffer(r Ref) error {
+	name, err := r.String()
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "low":
+		*l = 0
+	case "high":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %q", name)
+	}
+	return nil
+}
+
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalFlexBuffer(b *Builder) error {
+	b.Int(1)
+	return b.Int(2)
+}
+
+type Account struct {
+	Balance Money   `flexbuffers:"balance"`
+	Limit   *Money  `flexbuffers:"limit"`
+	Levels  []Level `flexbuffers:"levels"`
+	Level   Level   `flexbuffers:"level"`
+}
+
+func TestMarshalerInterfaces(t *testing.T) {
+	buff, err := Marshal(Money{Units: 10, Cents: 5})
+	require.NoError(t, err)
+	r := NewRef(buff)
+	require.Equal(t, VarType(VECTOR_INT2), r.context.ItemVarType())
+
+	in := &Account{Balance: Money{1, 50}, Limit: &Money{100, 0}, Levels: []Level{1, 0}, Level: 1}
+	buff, err = Marshal(in)
+	require.NoError(t, err)
+	var generic map[string]interface{}
+	require.NoError(t, Unmarshal(buff, &generic))
+	require.Equal(t, []int64{1, 50}, generic["balance"])
+	require.Equal(t, []interface{}{"high", "low"}, generic["levels"])
+	require.Equal(t, "high", generic["level"])
+
+	//Level only implements Marshaler through a pointer, so it is used for addressable values only
+	value, err := Marshal(*in)
+	require.NoError(t, err)
+	require.NoError(t, Unmarshal(value, &generic))
+	require.Equal(t, []interface{}{"high", "low"}, generic["levels"])
+	require.Equal(t, int64(1), generic["level"])
+
+	var out Account
+	require.NoError(t, Unmarshal(buff, &out))
+	require.Equal(t, Money{1, 50}, out.Balance)
+	require.Equal(t, &Money{100, 0}, out.Limit)
+	require.Equal(t, []Level{1, 0}, out.Levels)
+
+	_, err = Marshal(Money{Cents: 100})
+	require.Error(t, err)
+	_, err = Marshal([]interface{}{badMarshaler{}})
+	require.Error(t, err)
+
+	buff, err = Marshal(map[string]interface{}{"level": "medium"})
+	require.NoError(t, err)
+	require.Error(t, Unmarshal(buff, &out))
+}
+
+func TestStream(t *testing.T) {
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream)
+	require.NoError(t, enc.Encode(Address{Street: "Main St"}))
+	require.NoError(t, enc.Encode([]int{1, 2, 3}))
+	require.NoError(t, enc.Encode("last"))
+
+	dec := NewDecoder(bytes.NewReader(stream.Bytes()))
+	var addr Address
+	require.NoError(t, dec.Decode(&addr))
+	require.Equal(t, Address{Street: "Main St"}, addr)
+	r, err := dec.Next()
+	require.NoError(t, err)
+	ints, err := r.IntSlice()
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3}, ints)
+	var s string
+	require.NoError(t, dec.Decode(&s))
+	require.Equal(t, "last", s)
+	_, err = dec.Next()
+	require.Equal(t, io.EOF, err)
+
+	truncated := NewDecoder(bytes.NewReader(stream.Bytes()[:stream.Len()-1]))
+	require.NoError(t, truncated.Decode(&addr))
+	_, err = truncated.Next()
+	require.NoError(t, err)
+	_, err = truncated.Next()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	limited := NewDecoder(bytes.NewReader(stream.Bytes()))
+	limited.SetMaxSize(4)
+	_, err = limited.Next()
+	require.Error(t, err)
+}
+
+func TestBuilderSharing(t *testing.T) {
+	strs := []string{"ab", "ab"}
+	require.Equal(t, []byte{
+		0x02, 'a', 'b', 0x00,
+		0x02, 0x04, 0x05, 0x14, 0x14,
+		0x04, 0x28, 0x01}, mustMarshalWithOptions(t, BuilderOptions{ShareStrings: true}, strs))
+	require.Equal(t, mustMarshal(t, strs), mustMarshalWithOptions(t, BuilderOptions{ShareKeys: true}, strs))
+
+	maps := []map[string]int{{"a": 1}, {"a": 2}}
+	require.Equal(t, []byte{
+		'a', 0x00,
+		0x01, 0x03,
+		0x01, 0x01, 0x01, 0x01, 0x04,
+		0x01, 0x0a,
+		0x01, 0x01, 0x01, 0x02, 0x04,
+		0x02, 0x0a, 0x04, 0x24, 0x24,
+		0x04, 0x28, 0x01}, mustMarshalWithOptions(t, BuilderOptions{ShareKeys: true}, maps))
+	require.Equal(t, mustMarshal(t, maps), mustMarshalWithOptions(t, BuilderOptions{ShareStrings: true}, maps))
+
+	//strings and keys are pooled separately
+	mixed := []interface{}{"k", map[string]string{"k": "k"}, "k"}
+	buff := mustMarshalWithOptions(t, BuilderOptions{ShareStrings: true, ShareKeys: true}, mixed)
+	require.Less(t, len(buff), len(mustMarshal(t, mixed)))
+	var out []interface{}
+	require.NoError(t, Unmarshal(buff, &out))
+	require.Equal(t, []interface{}{"k", map[string]interface{}{"k": "k"}, "k"}, out)
+
+	b := NewBuilderWithOptions(BuilderOptions{ShareKeys: true})
+	require.NoError(t, b.StartVector())
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.IntWithKey("x", 1))
+	require.Error(t, b.IntWithKey("x", 2))
+	b.End()
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.StringWithKey("x", "y"))
+	b.End()
+	b.End()
+	buff = []byte{}
+	_, err := b.SerializeBuffer(&buff)
+	require.NoError(t, err)
+	require.NoError(t, Unmarshal(buff, &out))
+	require.Equal(t, []interface{}{map[string]interface{}{"x": int64(1)}, map[string]interface{}{"x": "y"}}, out)
+}
+
+func TestBuilderWidthOptions(t *testing.T) {
+	require.Equal(t, []byte{
+		0x02, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x00, 0x00,
+		0x02, 0x00, 0x00, 0x00,
+		0x08, 0x00, 0x00, 0x00, 0x2e, 0x04}, mustMarshalWithOptions(t, BuilderOptions{ForceMinBitWidth: W32}, []int{1, 2}))
+	//the root is widened like vectors and maps, the size prefix of a string is not
+	require.Equal(t, []byte{
+		0x03, 'a', 'b', 'c', 0x00, 0x00, 0x00, 0x00,
+		0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x14, 0x08}, mustMarshalWithOptions(t, BuilderOptions{ForceMinBitWidth: W64}, "abc"))
+	require.Equal(t, []byte{0x01, 0x00, 0x00, 0x00, 0x06, 0x04}, mustMarshalWithOptions(t, BuilderOptions{ForceMinBitWidth: W32}, 1))
+	require.Equal(t, []byte{0x00, 0x00, 0x20, 0x40, 0x0e, 0x04}, mustMarshalWithOptions(t, BuilderOptions{ForceMinBitWidth: W32}, 2.5))
+	b := NewBuilderWithOptions(BuilderOptions{ForceMinBitWidth: W32})
+	b.Reset()
+	require.NoError(t, b.AutoBuild(1))
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, byte(4), buff[len(buff)-1], "the width is kept by Reset")
+
+	nested := map[string]interface{}{"a": []interface{}{int64(1), "x"}, "b": 2.5}
+	var out map[string]interface{}
+	require.NoError(t, Unmarshal(mustMarshalWithOptions(t, BuilderOptions{ForceMinBitWidth: W16}, nested), &out))
+	require.Equal(t, nested, out)
+
+	var f float64
+	require.NoError(t, Unmarshal(mustMarshalWithOptions(t, BuilderOptions{}, 0.1), &f))
+	require.Equal(t, 0.1, f)
+	require.NoError(t, Unmarshal(mustMarshalWithOptions(t, BuilderOptions{Floats: FloatAlways32}, 0.1), &f))
+	require.Equal(t, float64(float32(0.1)), f)
+
+	require.Len(t, mustMarshalWithOptions(t, BuilderOptions{}, []float64{2.5}), 4+4+3)
+	require.Len(t, mustMarshalWithOptions(t, BuilderOptions{Floats: FloatAlways64}, []float64{2.5}), 8+8+3)
+	require.Len(t, mustMarshalWithOptions(t, BuilderOptions{Floats: FloatAlways32}, []float64{0.1}), 4+4+3)
+}
+
+func TestBuilderReset(t *testing.T) {
+	values := []interface{}{
+		Person{Name: "Ann", Age: 41, Home: &Address{Street: "Main"}, Previous: []Address{{Zip: "1"}}},
+		[]interface{}{1, "two", 3.5, nil, []byte{4}},
+		map[string]int{"x": 1, "y": 2},
+		"last",
+	}
+	b := NewBuilder()
+	for _, v := range values {
+		b.Reset()
+		require.NoError(t, b.AutoBuild(v))
+		buff, err := b.Bytes()
+		require.NoError(t, err)
+		require.Equal(t, mustMarshal(t, v), buff)
+	}
+
+	//options and their pools survive a reset
+	shared := NewBuilderWithOptions(BuilderOptions{ShareStrings: true})
+	for _, v := range values {
+		shared.Reset()
+		require.NoError(t, shared.AutoBuild(v))
+		buff, err := shared.Bytes()
+		require.NoError(t, err)
+		fresh := NewBuilderWithOptions(BuilderOptions{ShareStrings: true})
+		require.NoError(t, fresh.AutoBuild(v))
+		expected, err := fresh.Bytes()
+		require.NoError(t, err)
+		require.Equal(t, expected, buff)
+	}
+
+	//an unfinished builder can be reset as well
+	b.Reset()
+	require.NoError(t, b.StartVector())
+	require.NoError(t, b.Int(1))
+	b.Reset()
+	require.NoError(t, b.Int(7))
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, mustMarshal(t, 7), buff)
+
+	prefix := []byte{0xff}
+	buff, err = MarshalAppend(prefix, "s")
+	require.NoError(t, err)
+	require.Equal(t, append([]byte{0xff}, mustMarshal(t, "s")...), buff)
+
+	if raceEnabled {
+		t.Skip("builders are not reliably pooled with the race detector")
+	}
+	dst := make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		dst, err = MarshalAppend(dst[:0], &values[0])
+	})
+	require.NoError(t, err)
+	require.Zero(t, allocs)
+}
+
+func TestBuilderAddRef(t *testing.T) {
+	//a payload with typed vectors, indirect scalars, blobs, keys and wide offsets
+	p := NewBuilderWithOptions(BuilderOptions{ForceMinBitWidth: W64, ShareStrings: true})
+	require.NoError(t, p.StartMap())
+	require.NoError(t, p.StartTypedIntVectorWithKey("ids"))
+	require.NoError(t, p.Int(1))
+	require.NoError(t, p.Int(-300))
+	p.End()
+	require.NoError(t, p.StartFloatScalarWithKey("f"))
+	require.NoError(t, p.Float(2.5))
+	p.End()
+	require.NoError(t, p.StartUintTripleWithKey("rgb"))
+	require.NoError(t, p.Uint(1))
+	require.NoError(t, p.Uint(2))
+	require.NoError(t, p.Uint(3))
+	p.End()
+	require.NoError(t, p.StartVectorWithKey("mixed"))
+	require.NoError(t, p.Key("k"))
+	require.NoError(t, p.String("s"))
+	require.NoError(t, p.String("s"))
+	require.NoError(t, p.StartBlob([]byte{0, 1}))
+	p.End()
+	require.NoError(t, p.Null())
+	p.End()
+	p.End()
+	payload, err := p.Bytes()
+	require.NoError(t, err)
+	r := *NewRef(payload)
+
+	//an envelope around the payload and one of its fields
+	b := NewBuilder()
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.AddRefWithKey("body", r))
+	ids, err := r.MapIndex("ids")
+	require.NoError(t, err)
+	require.NoError(t, b.AddRefWithKey("ids", ids))
+	require.NoError(t, b.StringWithKey("kind", "event"))
+	b.End()
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	require.NoError(t, Verify(buff, VerifyOptions{}))
+	require.Less(t, len(buff), len(payload))
+
+	envelope := *NewRef(buff)
+	body, err := envelope.MapIndex("body")
+	require.NoError(t, err)
+	require.True(t, Equal(r, body))
+	for _, k := range []string{"ids", "f", "rgb", "mixed"} {
+		expected, err := r.MapIndex(k)
+		require.NoError(t, err)
+		copied, err := body.MapIndex(k)
+		require.NoError(t, err)
+		require.Equal(t, expected.context.ItemVarType(), copied.context.ItemVarType(), k)
+	}
+	copied, err := envelope.MapIndex("ids")
+	require.NoError(t, err)
+	require.Equal(t, VarType(VECTOR_INT), copied.context.ItemVarType())
+	require.True(t, Equal(ids, copied))
+
+	//a key can not be added to a map as a key, it is copied as a string
+	mixed, err := r.MapIndex("mixed")
+	require.NoError(t, err)
+	k, err := mixed.Index(0)
+	require.NoError(t, err)
+	b = NewBuilder()
+	require.NoError(t, b.StartVector())
+	require.NoError(t, b.AddRef(k))
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.AddRefWithKey("k", k))
+	b.End()
+	b.End()
+	buff, err = b.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, `[ "k", { "k": "k" } ]`, toJSON(t, buff, JSONOptions{}))
+	copied, err = NewRef(buff).Index(0)
+	require.NoError(t, err)
+	require.Equal(t, VarType(KEY), copied.context.ItemVarType())
+
+	//values have to fit where they are added
+	b = NewBuilder()
+	require.NoError(t, b.StartMap())
+	require.Error(t, b.AddRef(ids))
+	require.NoError(t, b.StartTypedIntVectorWithKey("x"))
+	require.Error(t, b.AddRef(k))
+}
+
+func TestBuilderKeyVectorSharing(t *testing.T) {
+	type record struct {
+		Name string
+		ID   int
+		Tags []string
+	}
+	records := make([]record, 100)
+	for i := range records {
+		records[i] = record{Name: fmt.Sprint("n", i), ID: i, Tags: []string{"x"}}
+	}
+	keyVectors := func(buff []byte) map[uint64]int {
+		r := *NewRef(buff)
+		kvs := map[uint64]int{}
+		for _, item := range r.All() {
+			kv, err := item.KeyVector()
+			require.NoError(t, err)
+			kvs[kv.index_0]++
+		}
+		return kvs
+	}
+	for _, opts := range []BuilderOptions{{}, {ShareKeys: true}, {ShareKeys: true, ShareStrings: true, ForceMinBitWidth: W32}} {
+		unshared := mustMarshalWithOptions(t, opts, records)
+		opts.ShareKeyVectors = true
+		shared := mustMarshalWithOptions(t, opts, records)
+		require.NoError(t, Verify(shared, VerifyOptions{}))
+		require.True(t, Equal(*NewRef(unshared), *NewRef(shared)))
+		require.Len(t, keyVectors(unshared), 100)
+		require.Len(t, keyVectors(shared), 1)
+		require.Less(t, len(shared), len(unshared))
+	}
+
+	//keys shared with nested maps and later values stay in the buffer
+	nested := []interface{}{
+		map[string]interface{}{"a": map[string]interface{}{"a": 1}},
+		map[string]interface{}{"a": 2},
+		[]interface{}{map[string]int{"b": 1}, map[string]int{"b": 2}},
+	}
+	for _, opts := range []BuilderOptions{{ShareKeyVectors: true}, {ShareKeyVectors: true, ShareKeys: true}} {
+		b := NewBuilderWithOptions(opts)
+		require.NoError(t, b.StartVector())
+		for _, v := range nested {
+			require.NoError(t, b.AutoBuild(v))
+		}
+		require.NoError(t, b.StartVector())
+		require.NoError(t, b.Key("b"))
+		b.End()
+		b.End()
+		buff, err := b.Bytes()
+		require.NoError(t, err)
+		require.NoError(t, Verify(buff, VerifyOptions{}))
+		require.Equal(t, `[ { "a": { "a": 1 } }, { "a": 2 }, [ { "b": 1 }, { "b": 2 } ], [ "b" ] ]`, toJSON(t, buff, JSONOptions{}))
+	}
+
+	//maps of the same schema share their key vector, unless their keys differ
+	b := NewBuilder()
+	require.NoError(t, b.StartVector())
+	for i := 0; i < 3; i++ {
+		require.NoError(t, b.StartMapWithSharedKeys("point"))
+		require.NoError(t, b.IntWithKey("y", int64(i)))
+		require.NoError(t, b.IntWithKey("x", int64(i)))
+		b.End()
+	}
+	require.NoError(t, b.StartMapWithSharedKeys("point"))
+	require.NoError(t, b.IntWithKey("x", 3))
+	b.End()
+	require.NoError(t, b.StartMapWithSharedKeys("other"))
+	require.NoError(t, b.IntWithKey("x", 4))
+	require.NoError(t, b.IntWithKey("y", 4))
+	b.End()
+	b.End()
+	buff, err := b.Bytes()
+	require.NoError(t, err)
+	require.NoError(t, Verify(buff, VerifyOptions{}))
+	require.Equal(t, `[ { "x": 0, "y": 0 }, { "x": 1, "y": 1 }, { "x": 2, "y": 2 }, { "x": 3 }, { "x": 4, "y": 4 } ]`,
+		toJSON(t, buff, JSONOptions{}))
+	require.Len(t, keyVectors(buff), 3)
+
+	//a Finder reads maps sharing a key vector without searching
+	v, err := NewRef(buff).AsVector()
+	require.NoError(t, err)
+	first, err := v.At(0)
+	require.NoError(t, err)
+	m, err := first.AsMap()
+	require.NoError(t, err)
+	f, err := m.Finder("y")
+	require.NoError(t, err)
+	second, err := v.At(2)
+	require.NoError(t, err)
+	m, err = second.AsMap()
+	require.NoError(t, err)
+	require.True(t, m.sharesKeys(f.kv))
+	y, err := f.Index(m, 0)
+	require.NoError(t, err)
+	i, err := y.Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), i)
+
+	//the pools are cleared by Reset
+	b.Reset()
+	require.NoError(t, b.StartMapWithSharedKeys("point"))
+	require.NoError(t, b.IntWithKey("z", 1))
+	b.End()
+	buff, err = b.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, `{ "z": 1 }`, toJSON(t, buff, JSONOptions{}))
+}
+
+func TestBuilderStructureSharing(t *testing.T) {
+	build := func(b *Builder) []byte {
+		require.NoError(t, b.StartVector())
+		for i := 0; i < 50; i++ {
+			require.NoError(t, b.StartMap())
+			require.NoError(t, b.IntWithKey("id", int64(i)))
+			require.NoError(t, b.StartMapWithKey("address"))
+			require.NoError(t, b.StringWithKey("city", "Springfield"))
+			require.NoError(t, b.StartTypedIntVectorWithKey("zip"))
+			require.NoError(t, b.Int(1))
+			require.NoError(t, b.Int(2))
+			b.End()
+			b.End()
+			require.NoError(t, b.StartVectorWithKey("tags"))
+			require.NoError(t, b.String("a"))
+			require.NoError(t, b.String("b"))
+			b.End()
+			require.NoError(t, b.StartBlobWithKey("raw", []byte{1, 2, 3}))
+			b.End()
+			//strings built piece by piece are not pooled, so the vector holding one is not shared
+			require.NoError(t, b.StartVectorWithKey("note"))
+			require.NoError(t, b.StartString())
+			require.NoError(t, b.Append("n"))
+			b.End()
+			b.End()
+			b.End()
+		}
+		b.End()
+		buff, err := b.Bytes()
+		require.NoError(t, err)
+		require.NoError(t, Verify(buff, VerifyOptions{}))
+		return append([]byte(nil), buff...)
+	}
+	plain := NewBuilder()
+	unshared := build(plain)
+	require.Equal(t, DedupStats{}, plain.DedupStats())
+
+	b := NewBuilderWithOptions(BuilderOptions{ShareStructures: true})
+	shared := build(b)
+	require.True(t, Equal(*NewRef(unshared), *NewRef(shared)))
+	require.Less(t, len(shared), len(unshared)/2)
+	stats := b.DedupStats()
+	require.Equal(t, 49*4, stats.Structures) //address, zip, tags and raw of every record but the first
+	require.Greater(t, stats.BytesSaved, 49*(3+2+3+3))
+
+	//readers see the same offsets
+	index := func(buff []byte, pointer string) uint64 {
+		r, err := NewRef(buff).Lookup(pointer)
+		require.NoError(t, err)
+		return r.index_0
+	}
+	for _, field := range []string{"address", "tags", "raw"} {
+		require.Equal(t, index(shared, "/0/"+field), index(shared, "/49/"+field))
+		require.NotEqual(t, index(unshared, "/0/"+field), index(unshared, "/49/"+field))
+	}
+	require.NotEqual(t, index(shared, "/0/note"), index(shared, "/49/note"))
+
+	//key vectors of maps that are not identical can still be shared
+	both := build(NewBuilderWithOptions(BuilderOptions{ShareStructures: true, ShareKeyVectors: true}))
+	require.True(t, Equal(*NewRef(unshared), *NewRef(both)))
+	require.Less(t, len(both), len(shared))
+
+	//the counters are cleared by Reset
+	b.Reset()
+	require.Equal(t, DedupStats{}, b.DedupStats())
+	require.Equal(t, shared, build(b))
+	require.Equal(t, stats, b.DedupStats())
+
+	//the saved bytes are counted with the widths the buffer is serialized with
+	v := [][]int{{1, 2, 3}, {1, 2, 3}}
+	b = NewBuilderWithOptions(BuilderOptions{ShareStructures: true})
+	require.NoError(t, b.AutoBuild(v))
+	require.Equal(t, DedupStats{Structures: 1}, b.DedupStats())
+	small, err := b.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, DedupStats{Structures: 1, BytesSaved: len(mustMarshal(t, v)) - len(small)}, b.DedupStats())
+	_, err = b.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, DedupStats{Structures: 1, BytesSaved: 4}, b.DedupStats())
+}
@@ -0,0 +1,173 @@
+package flexbuffers
+
+import "bytes"
+
+//Decides how Merge combines two vectors
+type VectorPolicy uint8
+
+const (
+	VectorReplace VectorPolicy = iota //the vector of src replaces the vector of dst, as in RFC 7396
+	VectorAppend                      //the items of src are appended to the items of dst
+)
+
+//Decides how Merge combines two values of different kinds, e.g. a map and a string. A NULL or missing value in dst
+//never conflicts, and integers and floats are not considered different kinds
+type ConflictPolicy uint8
+
+const (
+	ConflictReplace ConflictPolicy = iota //the value of src replaces the value of dst, as in RFC 7396
+	ConflictKeep                          //the value of dst is kept
+	ConflictError                         //Merge fails with a *RefError wrapping ErrTypeMismatch
+)
+
+//Options changing the result of Merge. The zero value follows RFC 7396
+type MergeOptions struct {
+	Vectors   VectorPolicy
+	Conflicts ConflictPolicy
+	Builder   BuilderOptions //options of the Builder writing the result
+}
+
+//Merge applies src to dst as a JSON merge patch (RFC 7396) and returns the result as a new flexbuffer. The maps of
+//src are merged into the maps of dst key by key, a NULL value in src deletes the key from dst, and any other value of
+//src replaces the value of dst, see MergeOptions for vectors and values of different kinds. Values of dst that are
+//not changed by src are copied as they are, merged maps and appended vectors are written as untyped maps and vectors
+func Merge(dst, src Ref, opts MergeOptions) ([]byte, error) {
+	b := NewBuilderWithOptions(opts.Builder)
+	if err := merge(b, nil, &dst, src, opts, 0); err != nil {
+		return nil, err
+	}
+	var buff []byte
+	if _, err := b.SerializeBuffer(&buff); err != nil {
+		return nil, err
+	}
+	return buff, nil
+}
+
+//adds the result of merging src into dst to the builder, under the key k if it is part of a map. dst is nil if it is
+//missing, in which case the null values of the maps of src are removed
+func merge(b *Builder, k *key, dst *Ref, src Ref, opts MergeOptions, depth int) error {
+	if err := src.checkDepth(depth); err != nil {
+		return err
+	}
+	if dst != nil && dst.context.ItemVarType() == NULL {
+		dst = nil
+	}
+	src_kind := src.kind()
+	if dst != nil && src_kind != kindNull && !mergeable(dst.kind(), src_kind) {
+		switch opts.Conflicts {
+		case ConflictKeep:
+			return b.addRef(k, *dst, depth)
+		case ConflictError:
+			return src.mismatch("object of type %s can not be merged into object of type %s",
+				src.context.ItemVarType().toString(), dst.context.ItemVarType().toString())
+		}
+		dst = nil
+	}
+	switch true {
+	case src_kind == kindMap:
+		return mergeMaps(b, k, dst, src, opts, depth)
+	case src_kind == kindVector && dst != nil && opts.Vectors == VectorAppend:
+		if err := b.startWithOptionalKey(k, b.arena.newVector()); err != nil {
+			return err
+		}
+		for _, r := range [...]Ref{*dst, src} {
+			for i := int64(0); i < int64(r.item_count); i++ {
+				item_ref, err := r.Index(i)
+				if err != nil {
+					return err
+				}
+				if err := b.addRef(nil, item_ref, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		b.End()
+		return nil
+	}
+	return b.addRef(k, src, depth)
+}
+
+func mergeable(x kind, y kind) bool {
+	if x == kindFloat {
+		x = kindInt
+	}
+	if y == kindFloat {
+		y = kindInt
+	}
+	return x == y
+}
+
+//merges the entries of both maps in key order, dst is nil or a map
+func mergeMaps(b *Builder, k *key, dst *Ref, src Ref, opts MergeOptions, depth int) error {
+	src_keys, err := src.KeyVector()
+	if err != nil {
+		return err
+	}
+	var dst_keys Ref
+	dst_count := int64(0)
+	if dst != nil {
+		if dst_keys, err = dst.KeyVector(); err != nil {
+			return err
+		}
+		dst_count = int64(dst.item_count)
+	}
+	if err := b.startWithOptionalKey(k, b.arena.newFlexMap()); err != nil {
+		return err
+	}
+	i, j := int64(0), int64(0)
+	for i < dst_count || j < int64(src.item_count) {
+		var dk, sk []byte
+		if i < dst_count {
+			if dk, err = dst_keys.keyBytesAt(i); err != nil {
+				return err
+			}
+		}
+		if j < int64(src.item_count) {
+			if sk, err = src_keys.keyBytesAt(j); err != nil {
+				return err
+			}
+		}
+		switch true {
+		case j == int64(src.item_count) || dk != nil && bytes.Compare(dk, sk) < 0:
+			//only in dst
+			val_ref, err := dst.Index(i)
+			if err != nil {
+				return err
+			}
+			if err := b.addRef(b.arena.newKey(string(dk)), val_ref, depth+1); err != nil {
+				return atSegment(err, keySegment(string(dk)))
+			}
+			i++
+		case i == dst_count || bytes.Compare(dk, sk) > 0:
+			//only in src
+			val_ref, err := src.Index(j)
+			if err != nil {
+				return err
+			}
+			if val_ref.context.ItemVarType() != NULL {
+				if err := merge(b, b.arena.newKey(string(sk)), nil, val_ref, opts, depth+1); err != nil {
+					return atSegment(err, keySegment(string(sk)))
+				}
+			}
+			j++
+		default:
+			val_ref, err := src.Index(j)
+			if err != nil {
+				return err
+			}
+			if val_ref.context.ItemVarType() != NULL {
+				dst_ref, err := dst.Index(i)
+				if err != nil {
+					return err
+				}
+				if err := merge(b, b.arena.newKey(string(sk)), &dst_ref, val_ref, opts, depth+1); err != nil {
+					return atSegment(err, keySegment(string(sk)))
+				}
+			}
+			i++
+			j++
+		}
+	}
+	b.End()
+	return nil
+}
@@ -0,0 +1,58 @@
+package flexbuffers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	//examples of RFC 7396, appendix A
+	tests := []struct {
+		dst, src, expected string
+	}{
+		{`{ "a": "b" }`, `{ "a": "c" }`, `{ "a": "c" }`},
+		{`{ "a": "b" }`, `{ "b": "c" }`, `{ "a": "b", "b": "c" }`},
+		{`{ "a": "b" }`, `{ "a": null }`, `{  }`},
+		{`{ "a": "b", "b": "c" }`, `{ "a": null }`, `{ "b": "c" }`},
+		{`{ "a": [ "b" ] }`, `{ "a": "c" }`, `{ "a": "c" }`},
+		{`{ "a": "c" }`, `{ "a": [ "b" ] }`, `{ "a": [ "b" ] }`},
+		{`{ "a": { "b": "c" } }`, `{ "a": { "b": "d", "c": null } }`, `{ "a": { "b": "d" } }`},
+		{`{ "a": [ { "b": "c" } ] }`, `{ "a": [ 1 ] }`, `{ "a": [ 1 ] }`},
+		{`[ "a", "b" ]`, `[ "c", "d" ]`, `[ "c", "d" ]`},
+		{`{ "a": "b" }`, `[ "c" ]`, `[ "c" ]`},
+		{`{ "a": "foo" }`, `null`, `null`},
+		{`{ "a": "foo" }`, `"bar"`, `"bar"`},
+		{`{ "e": null }`, `{ "a": 1 }`, `{ "a": 1, "e": null }`},
+		{`[ 1, 2 ]`, `{ "a": "b", "c": null }`, `{ "a": "b" }`},
+		{`{  }`, `{ "a": { "bb": { "ccc": null } } }`, `{ "a": { "bb": {  } } }`},
+	}
+	for _, tt := range tests {
+		buff, err := Merge(mustFromJSON(t, tt.dst), mustFromJSON(t, tt.src), MergeOptions{})
+		require.NoError(t, err, tt.src)
+		require.Equal(t, tt.expected, toJSON(t, buff, JSONOptions{}), tt.src)
+	}
+
+	//unchanged values keep their types
+	dst := *NewRef(mustMarshal(t, map[string]interface{}{"ids": []int16{1, 2}, "name": "x"}))
+	buff, err := Merge(dst, mustFromJSON(t, `{ "name": "y" }`), MergeOptions{})
+	require.NoError(t, err)
+	ids, err := NewRef(buff).MapIndex("ids")
+	require.NoError(t, err)
+	require.Equal(t, VarType(VECTOR_INT), ids.context.ItemVarType())
+
+	//vectors can be appended
+	buff, err = Merge(mustFromJSON(t, `{ "tags": [ "a" ], "n": 1 }`), mustFromJSON(t, `{ "tags": [ "b", "c" ], "n": [ 2 ] }`),
+		MergeOptions{Vectors: VectorAppend})
+	require.NoError(t, err)
+	require.Equal(t, `{ "n": [ 2 ], "tags": [ "a", "b", "c" ] }`, toJSON(t, buff, JSONOptions{}))
+
+	//conflicting kinds can be kept or rejected, numbers do not conflict
+	dst, src := mustFromJSON(t, `{ "a": { "b": 1 }, "c": 1, "d": null }`), mustFromJSON(t, `{ "a": "x", "c": 1.5, "d": [ 1 ] }`)
+	buff, err = Merge(dst, src, MergeOptions{Conflicts: ConflictKeep})
+	require.NoError(t, err)
+	require.Equal(t, `{ "a": { "b": 1 }, "c": 1.5, "d": [ 1 ] }`, toJSON(t, buff, JSONOptions{}))
+	_, err = Merge(dst, src, MergeOptions{Conflicts: ConflictError})
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	require.Equal(t, "$.a", err.(*RefError).Path())
+}
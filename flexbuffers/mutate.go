@@ -0,0 +1,101 @@
+package flexbuffers
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+//Values are mutated in place, in the buffer the Ref has been created from. The byte width of a value is decided when
+//the buffer is built, so a Mutate method returns false without changing anything if the new value does not fit in it,
+//or if the Ref does not hold a value of a matching type
+
+//writes u with the width of the value
+func (r Ref) mutate(u uint64) bool {
+	bytes, err := r.getBytes(r.index_0, B(r.context.ItemByteSize()))
+	if err != nil {
+		return false
+	}
+	var le [8]byte
+	binary.LittleEndian.PutUint64(le[:], u)
+	copy(bytes, le[:])
+	return true
+}
+
+//MutateInt updates an INT or INDIRECT_INT, or an UINT or INDIRECT_UINT if i is not negative
+func (r Ref) MutateInt(i int64) bool {
+	width := int(B(r.context.ItemByteSize()))
+	switch r.context.ItemVarType() {
+	case INT, INDIRECT_INT:
+		return intSize(i) <= width && r.mutate(uint64(i))
+	case UINT, INDIRECT_UINT:
+		return i >= 0 && uintSize(uint64(i)) <= width && r.mutate(uint64(i))
+	}
+	return false
+}
+
+//MutateUint updates an UINT or INDIRECT_UINT, or an INT or INDIRECT_INT if u fits in an int64
+func (r Ref) MutateUint(u uint64) bool {
+	width := int(B(r.context.ItemByteSize()))
+	switch r.context.ItemVarType() {
+	case UINT, INDIRECT_UINT:
+		return uintSize(u) <= width && r.mutate(u)
+	case INT, INDIRECT_INT:
+		return u <= math.MaxInt64 && intSize(int64(u)) <= width && r.mutate(u)
+	}
+	return false
+}
+
+//MutateFloat updates a FLOAT or INDIRECT_FLOAT. A float stored with 4 bytes can only be set to values that are
+//represented exactly by a float32, or NaN
+func (r Ref) MutateFloat(f float64) bool {
+	if !r.IsFloat() && r.context.ItemVarType() != INDIRECT_FLOAT {
+		return false
+	}
+	switch r.context.ItemByteSize() {
+	case b32:
+		return (floatSize(f) <= 4 || math.IsNaN(f)) && r.mutate(uint64(math.Float32bits(float32(f))))
+	case b64:
+		return r.mutate(math.Float64bits(f))
+	}
+	return false
+}
+
+//MutateBool updates a BOOL
+func (r Ref) MutateBool(l bool) bool {
+	if !r.IsBool() {
+		return false
+	}
+	if l {
+		return r.mutate(1)
+	}
+	return r.mutate(0)
+}
+
+//MutateString replaces the contents of a STRING with a string of the same length. Keys can not be mutated, as that
+//could break the order of a map. Buffers built with BuilderOptions.ShareStrings or ShareStructures store identical
+//strings once, so every other occurrence of the string changes as well
+func (r Ref) MutateString(s string) bool {
+	if !r.IsString() || uint64(len(s)) != r.item_count {
+		return false
+	}
+	bytes, err := r.getBytes(r.index_0, r.item_count)
+	if err != nil {
+		return false
+	}
+	copy(bytes, s)
+	return true
+}
+
+//MutateBlob replaces the contents of a BLOB with bytes of the same length. Buffers built with
+//BuilderOptions.ShareStructures store identical blobs once, so every other occurrence of the blob changes as well
+func (r Ref) MutateBlob(data []byte) bool {
+	if !r.IsBlob() || uint64(len(data)) != r.item_count {
+		return false
+	}
+	bytes, err := r.getBytes(r.index_0, r.item_count)
+	if err != nil {
+		return false
+	}
+	copy(bytes, data)
+	return true
+}
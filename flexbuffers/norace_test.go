@@ -0,0 +1,5 @@
+//go:build !race
+
+package flexbuffers
+
+const raceEnabled = false
@@ -1,15 +1,18 @@
 package flexbuffers
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math"
+	"sort"
 )
 
 type elementHandler interface {
 	insertOffsetToObject(iStructure, int) (int, error) //defines policy for adding offsets
 	insertElement(element, int) (int, error)           //defines policy for adding all types of elements
-	updateBitWidth()
+	insertOffset(iStructure, int) (int, error)         //defines policy for adding offsets to structures owned by another structure
+	updateBitWidth(bufSize int)                        //bufSize is the size of the buffer right before the elements get serialized
 	serializeElems(*[]byte) (int, error)
 	elemsCount() int
 }
@@ -25,6 +28,8 @@ type iStructure interface { //eg. flexMap,vector,typedVector, Scalars,Tuples,Tri
 	offsetHandler
 	getVtype() VarType
 	getBsize() ByteSize
+	setMinBitWidth(ByteSize)
+	base() *structure
 }
 
 //An element must be embedded inside a structure and directly represents data. This includes offsets to structures
@@ -34,7 +39,8 @@ type element struct {
 	absIndex   uint64 //stores the absolute index of an element. For offsets stores the absolute index of target
 	fieldType  VarType
 	fieldSize  ByteSize
-	targetSize ByteSize //for offsets - stores the bit width of the target structure. For other elements - remains empty
+	targetSize ByteSize   //for offsets - stores the bit width of the target structure. For other elements - remains empty
+	target     iStructure //for offsets - the target structure. For other elements - remains nil
 }
 
 func newUINT(u uint64) element {
@@ -57,14 +63,10 @@ func newINT(i int64) element {
 
 func newFLOAT(f float64) element {
 	var o element
-	s := floatSize(f)
-	if s == 4 {
-		binary.LittleEndian.PutUint32(o.bytes[:], math.Float32bits(float32(f)))
-	} else {
-		binary.LittleEndian.PutUint64(o.bytes[:], math.Float64bits(f))
-	}
+	//always store the full precision, the value is narrowed down to float32 when serialized with 4 bytes
+	binary.LittleEndian.PutUint64(o.bytes[:], math.Float64bits(f))
 	o.fieldType = FLOAT
-	o.fieldSize = b(s)
+	o.fieldSize = b(floatSize(f))
 	return o
 }
 
@@ -86,29 +88,46 @@ func newNULL() element {
 	return o
 }
 
-func newOffset(index0 uint64, targetType VarType, targetSize ByteSize) element {
-	//TODO: ensure vType is of structure type
-	var o element
-	//TODO: optimize
-	binary.LittleEndian.PutUint64(o.bytes[:], index0)
-	o.fieldType = targetType
-	o.targetSize = targetSize
-	o.fieldSize = ByteSize(uintSize(index0))
-	o.absIndex = index0
-	return o
+func (e element) isOffset() bool {
+	return !isInline(e.fieldType)
 }
 
-func (e element) serialize(buff *[]byte, args ...interface{}) (int, error) {
-	n := len(*buff)
-	if len(args) == 0 {
-		return n, fmt.Errorf("unable to serialize an element: Field size not provided")
+//returns the bit width required to store the element at the given position of a structure that starts
+//(after alignment) right after bufSize bytes. Offsets grow with the width of the structure, so each width is tried in turn
+func (e element) bitWidth(bufSize int, elemIndex int) ByteSize {
+	if !e.isOffset() {
+		return e.fieldSize
 	}
-	byteWidth, ok := args[0].(ByteSize)
-	if !ok {
-		return n, fmt.Errorf("unable to serialize an element: Expected parameter of type ByteSize but got %T", args[0])
+	for bs := b8; bs < b64; bs++ {
+		width := int(B(bs))
+		loc := bufSize + paddingBytes(bufSize, width) + elemIndex*width
+		if uintSize(uint64(loc)-e.absIndex) <= width {
+			return bs
+		}
+	}
+	return b64
+}
+
+func (e element) serialize(buff *[]byte, byteWidth ByteSize) (int, error) {
+	n := len(*buff)
+	width := B(byteWidth)
+	switch true {
+	case e.isOffset():
+		if e.absIndex > uint64(n) {
+			return n, fmt.Errorf("unable to serialize an offset: target structure of type %s has not been serialized yet", e.fieldType.toString())
+		}
+		var bytes [8]byte
+		binary.LittleEndian.PutUint64(bytes[:], uint64(n)-e.absIndex)
+		*buff = append(*buff, bytes[:width]...)
+	case e.fieldType == FLOAT && width == 4:
+		var bytes [4]byte
+		binary.LittleEndian.PutUint32(bytes[:], math.Float32bits(float32(math.Float64frombits(binary.LittleEndian.Uint64(e.bytes[:])))))
+		*buff = append(*buff, bytes[:]...)
+	case e.fieldType == FLOAT && width < 4:
+		return n, fmt.Errorf("unable to serialize a float with %d byte(s)", width)
+	default:
+		*buff = append(*buff, e.bytes[:width]...)
 	}
-	*buff = append(*buff, e.bytes[:B(byteWidth)]...)
-	e.absIndex = uint64(n)
 	return n, nil
 }
 
@@ -117,12 +136,38 @@ func (e element) serialize(buff *[]byte, args ...interface{}) (int, error) {
 type structure struct {
 	offsetPtrs []*element //can bind to multiple offsets
 	elems      []*element
-	children   []iStructure
-	vType      VarType  //TODO: remove redundant member
-	bSize      ByteSize //maximum element size
+	children   []iStructure //child structures, in the order they have been added (and will be serialized)
+	vType      VarType      //TODO: remove redundant member
+	bSize      ByteSize     //maximum element size
+	minBSize   ByteSize     //lower bound of bSize, see BuilderOptions.ForceMinBitWidth
+	absIndex   uint64       //absolute index of the first element, known once serialized
+	arena      *arena       //allocates the elements, nil for structures created outside of a Builder
 	//maybe bWidth should be a special enum type
 }
 
+//prepares a recycled structure for reuse, keeping the capacity of its slices
+func (s *structure) reset(a *arena, vType VarType) {
+	s.offsetPtrs = s.offsetPtrs[:0]
+	s.elems = s.elems[:0]
+	s.children = s.children[:0]
+	s.vType = vType
+	s.bSize = 0
+	s.minBSize = 0
+	s.absIndex = 0
+	s.arena = a
+}
+
+func (s *structure) newElement(e element) *element {
+	var p *element
+	if s.arena != nil {
+		p = s.arena.element()
+	} else {
+		p = new(element)
+	}
+	*p = e
+	return p
+}
+
 func (s *structure) elemsCount() int {
 	return len(s.elems)
 }
@@ -142,18 +187,21 @@ func (s *structure) getBsize() ByteSize {
 	return s.bSize
 }
 
-func serialize(s iStructure, buff *[]byte, apply_padding bool) (int, error) {
+func (s *structure) setMinBitWidth(bs ByteSize) {
+	s.minBSize = bs
+}
+
+func (s *structure) base() *structure {
+	return s
+}
+
+//Children are serialized depth-first before their parents, so that every offset points backwards
+func serialize(s iStructure, buff *[]byte) (int, error) {
 	if i, err := s.serializeChildren(buff); err != nil {
 		return i, err
 	}
-	i, err := s.serializeElems(buff)
-	if err != nil {
-		return i, err
-	}
-	if s.elemsCount() != 0 && apply_padding {
-		appendPadding(buff, int(B(s.getBsize())))
-	}
-	return i, nil
+	s.updateBitWidth(len(*buff))
+	return s.serializeElems(buff)
 }
 
 func addElement(s iStructure, elem element) (int, error) {
@@ -165,6 +213,7 @@ func addOffsetToObject(s iStructure, obj iStructure) (int, error) {
 }
 
 func (s *structure) updateOffsets(index0 uint64) error {
+	s.absIndex = index0
 	for _, sp := range s.offsetPtrs {
 		sp.absIndex = index0
 		sp.fieldType = s.vType
@@ -173,57 +222,61 @@ func (s *structure) updateOffsets(index0 uint64) error {
 	return nil
 }
 
-func (s *structure) updateBitWidth() {
-	s.bSize = 0
-	for _, elem := range s.elems {
-		if elem.fieldSize > s.bSize {
-			s.bSize = elem.fieldSize
+//computes the bit width required by all elements, prefix is the number of fields stored in front of the elements
+func (s *structure) elemsBitWidth(bufSize int, prefix int) ByteSize {
+	bSize := s.minBSize
+	for i, elem := range s.elems {
+		if bs := elem.bitWidth(bufSize, prefix+i); bs > bSize {
+			bSize = bs
 		}
 	}
+	return bSize
+}
+
+func (s *structure) updateBitWidth(bufSize int) {
+	s.bSize = s.elemsBitWidth(bufSize, 0)
+}
+
+func paddingBytes(bufSize int, scalarSize int) int {
+	return (^bufSize + 1) & (scalarSize - 1)
 }
 
-//HELP: flatc does not apply padding before root
 func appendPadding(buff *[]byte, scalarSize int) {
-	bufSize := len(*buff)
-	padding := (^bufSize + 1) & (scalarSize - 1)
-	/*word_size := 4
-	bytes := len(*buff)
-	padding := (word_size - (bytes % word_size)) % word_size*/
-	if padding != 0 {
-		*buff = append(*buff, make([]byte, padding)...)
-	}
+	var zeros [8]byte
+	*buff = append(*buff, zeros[:paddingBytes(len(*buff), scalarSize)]...)
+}
 
+func appendUint(buff *[]byte, u uint64, byteWidth ByteSize) {
+	var bytes [8]byte
+	binary.LittleEndian.PutUint64(bytes[:], u)
+	*buff = append(*buff, bytes[:B(byteWidth)]...)
 }
 
 func (s *structure) serializeChildren(buff *[]byte) (int, error) {
 	for _, ch := range s.children {
-		i, err := serialize(ch, buff, true)
+		i, err := serialize(ch, buff)
 		if err != nil {
 			return i, err
 		}
 	}
-	s.updateBitWidth()
 	return len(*buff), nil
 }
 
 func (s *structure) serializeElems(buff *[]byte) (int, error) {
 	index0 := len(*buff)
-	for i, elem := range s.elems {
-		if !isInline(elem.fieldType) {
-			*s.elems[i] = newOffset(uint64(index0+i-int(elem.absIndex)), elem.fieldType, elem.targetSize)
-		}
-		i, err := s.elems[i].serialize(buff, s.bSize)
+	for _, elem := range s.elems {
+		i, err := elem.serialize(buff, s.bSize)
 		if err != nil {
 			return i, err
 		}
 	}
-	err := s.updateOffsets(uint64(index0)) //TODO: fix problem with key vector not having any offsets to update
+	err := s.updateOffsets(uint64(index0))
 	return index0, err
 }
 
 func (s *structure) allowsElemType(elemT VarType) bool {
 	switch s.vType {
-	case VECTOR_UINT, INDIRECT_UINT, VECTOR_UINT2, VECTOR_UINT3, VECTOR_UINT4, BLOB, KEY, STRING:
+	case VECTOR_UINT, INDIRECT_UINT, VECTOR_UINT2, VECTOR_UINT3, VECTOR_UINT4:
 		return elemT == UINT
 	case VECTOR_INT, INDIRECT_INT, VECTOR_INT2, VECTOR_INT3, VECTOR_INT4:
 		return elemT == INT
@@ -235,31 +288,17 @@ func (s *structure) allowsElemType(elemT VarType) bool {
 		return elemT == KEY
 	case VECTOR_STRING_DEPRECATED:
 		return elemT == STRING
-	}
-	return true
-}
-
-func (s *structure) allowsElemSize(elemS ByteSize) bool {
-	switch s.vType {
-	case BLOB:
-		return elemS == b8
-	case KEY:
-		return elemS == b8
-	case STRING:
-		return elemS == b8
+	case BLOB, KEY, STRING:
+		return false
 	}
 	return true
 }
 
 func (s *structure) allows(elem *element) error {
 	elemT := elem.fieldType
-	elemS := elem.fieldSize
 	if !s.allowsElemType(elemT) {
 		return fmt.Errorf("unable to add element of type %s to a structure of type %s", elemT.toString(), s.vType.toString())
 	}
-	if !s.allowsElemSize(elemS) {
-		return fmt.Errorf("unable to add element of size %d byte(s) to a structure of type %s", int(elemS), s.vType.toString())
-	}
 	if s.isFull() {
 		return fmt.Errorf("unable to add any more elements: structure of type %s is full", s.vType.toString())
 	}
@@ -298,30 +337,33 @@ func (s *structure) insertElement(e element, index int) (int, error) {
 	length := len(s.elems)
 	if index <= length-1 {
 		s.elems = append(s.elems[:index+1], s.elems[index:]...)
-		s.elems[index] = &e
+		s.elems[index] = s.newElement(e)
 	} else {
-		s.elems = append(s.elems, &e)
+		s.elems = append(s.elems, s.newElement(e))
 		index = length
 	}
 	return index, nil
 }
 
-func (s *structure) insertOffsetToObject(o iStructure, index int) (int, error) {
+//inserts an offset bound to the given structure, without taking ownership of it
+func (s *structure) insertOffset(o iStructure, index int) (int, error) {
 	offset := element{fieldType: o.getVtype()}
 	n, err := s.insertElement(offset, index)
 	if err != nil {
 		return n, err
 	}
+	s.elems[n].target = o
 	o.bindOffset(s.elems[n])
-	//insert the structure at the same index as the offset(if possible). Otherwise insert at the end
-	length := len(s.children)
-	if n <= length-1 {
-		s.children = append(s.children[:n+1], s.children[n:]...)
-		s.children[n] = o
-	} else {
-		s.children = append(s.children, o)
-		n = length
+	return n, nil
+}
+
+func (s *structure) insertOffsetToObject(o iStructure, index int) (int, error) {
+	n, err := s.insertOffset(o, index)
+	if err != nil {
+		return n, err
 	}
+	//children are kept in insertion order, which is also their serialization order
+	s.children = append(s.children, o)
 	return n, nil
 }
 
@@ -338,14 +380,18 @@ func newTypedVector(vType VarType) *typedVector {
 	return v
 }
 
+func (tv *typedVector) updateBitWidth(bufSize int) {
+	//the size prefix is stored with the width of the elements
+	tv.bSize = tv.elemsBitWidth(bufSize, 1)
+	if bs := b(uintSize(uint64(len(tv.elems)))); bs > tv.bSize {
+		tv.bSize = bs
+	}
+}
+
 func (tv *typedVector) serializeElems(buff *[]byte) (int, error) {
+	appendPadding(buff, int(B(tv.bSize)))
 	//prepend vector size
-	n := uint64(len(tv.elems))
-	//TODO: optimize
-	bytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(bytes, n)
-	bytes = bytes[:B(tv.bSize)]
-	*buff = append(*buff, bytes...)
+	appendUint(buff, uint64(len(tv.elems)), tv.bSize)
 	return tv.structure.serializeElems(buff)
 }
 
@@ -378,6 +424,7 @@ func (v *vector) serializeElems(buff *[]byte) (int, error) {
 	return index0, err
 }
 
+//Scalars, tuples, triples and quads have a size implied by their type and are stored without a size prefix
 type fixedTypedVector struct {
 	typedVector
 }
@@ -391,21 +438,51 @@ func newFixedTypedVector(vType VarType) *fixedTypedVector {
 	return v
 }
 
+func (v *fixedTypedVector) serializeElems(buff *[]byte) (int, error) {
+	if !v.isFull() {
+		return len(*buff), fmt.Errorf("unable to serialize a structure of type %s with %d element(s)", v.vType.toString(), len(v.elems))
+	}
+	appendPadding(buff, int(B(v.bSize)))
+	return v.structure.serializeElems(buff)
+}
+
+//Blob-like structures store raw bytes instead of elements
 type blob struct {
 	typedVector
+	data []byte
 }
 
 func newBlob(bytes []byte) *blob {
 	v := new(blob)
 	v.vType = BLOB
 	v.bSize = b8
+	v.Append(bytes)
 	return v
 }
 
 func (s *blob) Append(bytes []byte) {
-	for _, b := range bytes {
-		s.addElement(newUINT(uint64(b)))
-	}
+	s.data = append(s.data, bytes...)
+}
+
+func (s *blob) elemsCount() int {
+	return len(s.data)
+}
+
+//the size prefix of blobs, strings and keys always uses the smallest width, as in the reference implementation
+func (s *blob) setMinBitWidth(bs ByteSize) {}
+
+func (s *blob) updateBitWidth(bufSize int) {
+	//the width of a blob is the width of its size prefix, the bytes themselves are always stored with 1 byte
+	s.bSize = b(uintSize(uint64(len(s.data))))
+}
+
+func (s *blob) serializeElems(buff *[]byte) (int, error) {
+	appendPadding(buff, int(B(s.bSize)))
+	appendUint(buff, uint64(len(s.data)), s.bSize)
+	index0 := len(*buff)
+	*buff = append(*buff, s.data...)
+	err := s.updateOffsets(uint64(index0))
+	return index0, err
 }
 
 type flexString struct {
@@ -420,6 +497,8 @@ func newFlexString(str string) *flexString {
 	return v
 }
 
+func (s *flexString) setMinBitWidth(bs ByteSize) {}
+
 func (s *flexString) serializeElems(buff *[]byte) (int, error) {
 	n, err := s.blob.serializeElems(buff)
 	*buff = append(*buff, byte(0)) //append 0-termination byte
@@ -427,29 +506,15 @@ func (s *flexString) serializeElems(buff *[]byte) (int, error) {
 }
 
 func (s *flexString) Append(str string) {
-	for _, char := range str {
-		s.addElement(newUINT(uint64(char)))
-	}
+	s.data = append(s.data, str...)
 }
 
 func (s *flexString) Equals(other *flexString) bool {
-	if len(s.elems) != len(other.elems) {
-		return false
-	}
-	for i := range s.elems {
-		if s.elems[i] != other.elems[i] {
-			return false
-		}
-	}
-	return true
+	return s.toString() == other.toString()
 }
 
 func (s *flexString) toString() string {
-	str := ""
-	for _, elem := range s.elems {
-		str += string(elem.bytes[0])
-	}
-	return str
+	return string(s.data)
 }
 
 type key struct {
@@ -464,10 +529,19 @@ func newKey(str string) *key {
 	return v
 }
 
+func (k *key) setMinBitWidth(bs ByteSize) {}
+
+func (k *key) updateBitWidth(bufSize int) {
+	k.bSize = b8
+}
+
+//keys are neither aligned nor size prefixed
 func (k *key) serializeElems(buff *[]byte) (int, error) {
-	n, err := k.structure.serializeElems(buff)
+	index0 := len(*buff)
+	*buff = append(*buff, k.data...)
 	*buff = append(*buff, byte(0)) //append 0-termination byte
-	return n, err
+	err := k.updateOffsets(uint64(index0))
+	return index0, err
 }
 
 type keyVector struct {
@@ -480,9 +554,12 @@ func newKeyVector() *keyVector {
 	return v
 }
 
+//A map is an untyped vector of values, prefixed with an offset to a sorted vector of keys and that vector's byte width
 type flexMap struct {
 	vector
-	keys *keyVector
+	keys   *keyVector
+	shared *keyVector //key vector of an earlier map with the same keys, serialized instead of keys if set
+	schema string     //maps started with the same schema share their key vector, see Builder.StartMapWithSharedKeys
 }
 
 func newFlexMap(args ...interface{}) *flexMap {
@@ -507,6 +584,15 @@ func (m *flexMap) addOffsetToObject(o iStructure) (int, error) {
 	return m.insertOffsetToObject(o, len(m.elems))
 }
 
+func (m *flexMap) setMinBitWidth(bs ByteSize) {
+	m.minBSize = bs
+	m.keys.setMinBitWidth(bs)
+}
+
+func (m *flexMap) insertOffset(o iStructure, index int) (int, error) {
+	return -1, fmt.Errorf("can not insert offset without key into map. Use addSharedOffsetWithKey(*key,bool,iStructure)")
+}
+
 func (m *flexMap) insertElement(e element, index int) (int, error) {
 	return -1, fmt.Errorf("can not insert element without key into map. Use addElementWithKey(*key,element)")
 }
@@ -515,91 +601,145 @@ func (m *flexMap) addElement(o iStructure) (int, error) {
 	return m.insertOffsetToObject(o, len(m.elems))
 }
 
+func (m *flexMap) keyAt(i int) *key {
+	return m.keys.children[i].(*key)
+}
+
 func (m *flexMap) containsKey(k *key) bool {
-	for _, c := range m.keys.children {
-		K := c.(*key)
-		if K.Equals(&k.flexString) {
-			return true
-		}
-	}
-	return false
+	i := m.determineKeyInsertionIndex(k)
+	return i > 0 && bytes.Equal(m.keyAt(i-1).data, k.data)
 }
 
+//binary search for the index of the first key greater than newkey
 func (m *flexMap) determineKeyInsertionIndex(newkey *key) int {
-	var DAC func([]iStructure) int
-	DAC = func(subslice []iStructure) int {
-		length := len(subslice)
-		if length == 0 {
-			return 0
-		} else if length == 1 {
-			key := subslice[0].(*key)
-			if newkey.toString() < key.toString() {
-				return 0
-			} else {
-				return 1
-			}
-		} else {
-			pivot := length / 2
-			key := subslice[pivot].(*key)
-			if newkey.toString() < key.toString() {
-				return DAC(subslice[:pivot])
-			} else {
-				ss := subslice[pivot+1:]
-				return length - len(ss) + DAC(ss)
-			}
-		}
-	}
-	return DAC(m.keys.children)
+	return sort.Search(len(m.keys.children), func(i int) bool {
+		return bytes.Compare(newkey.data, m.keyAt(i).data) < 0
+	})
 }
 
-func (m *flexMap) addElementWithKey(k *key, e element) (int, error) {
+//the key vector only holds offsets to the keys, the keys themselves are children of the map
+//so that they are serialized in insertion order, interleaved with the values. Keys shared with another map are not owned
+func (m *flexMap) insertKey(k *key, owned bool) (int, error) {
 	if m.containsKey(k) {
 		return -1, fmt.Errorf("the key %s already exists within the map - duplicate keys are not allowed", k.toString())
 	}
 	index := m.determineKeyInsertionIndex(k)
-	n, err := m.keys.insertOffsetToObject(k, index)
+	n, err := m.keys.insertOffset(k, index)
+	if err != nil {
+		return n, err
+	}
+	m.keys.children = append(m.keys.children, nil)
+	copy(m.keys.children[n+1:], m.keys.children[n:])
+	m.keys.children[n] = k
+	if owned {
+		m.children = append(m.children, k)
+	}
+	return n, nil
+}
+
+func (m *flexMap) addElementWithKey(k *key, ownsKey bool, e element) (int, error) {
+	n, err := m.insertKey(k, ownsKey)
 	if err != nil {
 		return n, err
 	}
 	return m.structure.insertElement(e, n)
 }
 
-func (m *flexMap) addOffsetWithKey(k *key, o iStructure) (int, error) {
-	if m.containsKey(k) {
-		return -1, fmt.Errorf("the key already exists within the map - duplicate keys are not allowed")
+func (m *flexMap) addOffsetWithKey(k *key, ownsKey bool, o iStructure) (int, error) {
+	n, err := m.insertKey(k, ownsKey)
+	if err != nil {
+		return n, err
 	}
-	index := m.determineKeyInsertionIndex(k)
-	n, err := m.keys.insertOffsetToObject(k, index)
+	return m.structure.insertOffsetToObject(o, n)
+}
+
+func (m *flexMap) addSharedOffsetWithKey(k *key, ownsKey bool, o iStructure) (int, error) {
+	n, err := m.insertKey(k, ownsKey)
 	if err != nil {
 		return n, err
 	}
-	return m.structure.insertOffsetToObject(o, index)
+	return m.structure.insertOffset(o, n)
 }
 
 func (m *flexMap) serializeChildren(buff *[]byte) (int, error) {
 	if i, err := m.structure.serializeChildren(buff); err != nil {
 		return i, err
 	}
-	i, err := serialize(m.keys, buff, true)
-	if err != nil {
-		return i, err
+	if m.shared != nil {
+		//serialized with the map it belongs to
+		return len(*buff), nil
+	}
+	return serializeKeyVector(m.keys, buff)
+}
+
+//returns the key vector the map points to
+func (m *flexMap) keyVector() *keyVector {
+	if m.shared != nil {
+		return m.shared
 	}
-	kbw := b(uintSize(uint64(i)))
-	if kbw > m.bSize {
-		m.bSize = kbw
+	return m.keys
+}
+
+//reports whether kv holds the same keys as the map
+func (m *flexMap) sameKeys(kv *keyVector) bool {
+	if len(kv.children) != len(m.keys.children) {
+		return false
 	}
-	//TODO: optimize
+	for i := range kv.children {
+		if !bytes.Equal(kv.children[i].(*key).data, m.keyAt(i).data) {
+			return false
+		}
+	}
+	return true
+}
+
+//points the map to kv, the key vector of an earlier map with the same keys. The keys owned by the map that nothing
+//else refers to are not serialized anymore, their indices in the key vector are returned
+func (m *flexMap) shareKeyVector(kv *keyVector) []int {
+	m.shared = kv
+	var dropped []int
+	for i := range m.keys.children {
+		k := m.keyAt(i)
+		if len(k.offsetPtrs) != 1 {
+			continue
+		}
+		for j, ch := range m.children {
+			if ch == iStructure(k) {
+				m.children = append(m.children[:j], m.children[j+1:]...)
+				dropped = append(dropped, i)
+				break
+			}
+		}
+	}
+	return dropped
+}
+
+//the keys are owned by the map, so only the offsets of the key vector are serialized
+func serializeKeyVector(kv *keyVector, buff *[]byte) (int, error) {
+	kv.updateBitWidth(len(*buff))
+	return kv.serializeElems(buff)
+}
+
+func (m *flexMap) updateBitWidth(bufSize int) {
+	//the map is prefixed with the key vector offset, the key vector byte width and the size
+	m.bSize = m.elemsBitWidth(bufSize, 3)
+	if bs := b(uintSize(uint64(len(m.elems)))); bs > m.bSize {
+		m.bSize = bs
+	}
+	keysOffset := element{fieldType: VECTOR_KEY, absIndex: m.keyVector().absIndex}
+	if bs := keysOffset.bitWidth(bufSize, 0); bs > m.bSize {
+		m.bSize = bs
+	}
+}
+
+func (m *flexMap) serializeElems(buff *[]byte) (int, error) {
+	appendPadding(buff, int(B(m.bSize)))
 	//append offset to keys vector
-	bytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(bytes, uint64(len(*buff)-i))
-	bytes = bytes[:B(m.bSize)]
-	*buff = append(*buff, bytes...)
+	kv := m.keyVector()
+	appendUint(buff, uint64(len(*buff))-kv.absIndex, m.bSize)
 	//append key vector byte width
-	bytes = make([]byte, 8)
-	binary.LittleEndian.PutUint64(bytes, uint64(B(m.keys.bSize)))
-	bytes = bytes[:B(m.bSize)]
-	*buff = append(*buff, bytes...)
-	return len(*buff), nil
+	appendUint(buff, B(kv.bSize), m.bSize)
+	return m.vector.serializeElems(buff)
 }
 
 type root struct {
@@ -610,16 +750,19 @@ func (r *root) serializeChildren(buff *[]byte) (int, error) {
 	i := len(*buff)
 	if len(r.children) != 0 {
 		ch := r.children[0]
-		i, err := serialize(ch, buff, false)
+		i, err := serialize(ch, buff)
 		if err != nil {
 			return i, err
 		}
 	}
-	r.updateBitWidth()
 	return i, nil
 }
 
 func (r *root) serializeElems(buff *[]byte) (int, error) {
+	if len(r.elems) == 0 {
+		return len(*buff), fmt.Errorf("unable to serialize an empty buffer: no root element has been added")
+	}
+	appendPadding(buff, int(B(r.bSize)))
 	i, err := r.structure.serializeElems(buff)
 	if err != nil {
 		return i, err
@@ -642,9 +785,16 @@ func (r *root) insertElement(elem element, index int) (int, error) {
 	return r.structure.insertElement(elem, 0)
 }
 
+func (r *root) insertOffset(o iStructure, index int) (int, error) {
+	if len(r.elems) > 0 {
+		return -1, fmt.Errorf("can not insert more than 1 element to root")
+	}
+	return r.structure.insertOffset(o, 0)
+}
+
 func (r *root) insertOffsetToObject(o iStructure, index int) (int, error) {
-	if len(r.children) > 0 {
-		return -1, fmt.Errorf("can not insert more than 1 child structure to root")
+	if len(r.elems) > 0 {
+		return -1, fmt.Errorf("can not insert more than 1 element to root")
 	}
 	return r.structure.insertOffsetToObject(o, 0)
 }
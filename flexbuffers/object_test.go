@@ -0,0 +1,114 @@
+package flexbuffers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerializeLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func(b *Builder) error
+		expected []byte
+	}{
+		{"string", func(b *Builder) error { return b.String("hi") }, []byte{0x02, 'h', 'i', 0x00, 0x03, 0x14, 0x01}},
+		{"blob", func(b *Builder) error {
+			if err := b.StartBlob([]byte{0xff, 0x00, 0x7f}); err != nil {
+				return err
+			}
+			b.End()
+			return nil
+		}, []byte{0x03, 0xff, 0x00, 0x7f, 0x03, 0x64, 0x01}},
+		//the vector is aligned to the 4 byte width of the float and the string offset counts from its own position
+		{"mixed vector", func(b *Builder) error {
+			b.StartVector()
+			b.Int(1)
+			b.String("a")
+			b.Float(2.5)
+			b.End()
+			return nil
+		}, []byte{
+			0x01, 'a', 0x00, 0x00,
+			0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x40,
+			0x06, 0x14, 0x0e, 0x0f, 0x2a, 0x01}},
+		//fixed typed vectors have no size prefix
+		{"triple", func(b *Builder) error {
+			b.StartIntTriple()
+			b.Int(1)
+			b.Int(2)
+			b.Int(3)
+			b.End()
+			return nil
+		}, []byte{0x01, 0x02, 0x03, 0x03, 0x4c, 0x01}},
+		//keys are serialized in insertion order, interleaved with the values
+		{"map", func(b *Builder) error {
+			b.StartMap()
+			b.StringWithKey("a", "x")
+			b.IntWithKey("b", 1)
+			b.StartVectorWithKey("c")
+			b.Int(1)
+			b.Int(2)
+			b.End()
+			b.End()
+			return nil
+		}, []byte{
+			'a', 0x00, 0x01, 'x', 0x00, 'b', 0x00, 'c', 0x00, 0x02, 0x01, 0x02, 0x04, 0x04,
+			0x03, 0x0f, 0x0b, 0x0a,
+			0x03, 0x01, 0x03, 0x12, 0x01, 0x0d, 0x14, 0x04, 0x28,
+			0x06, 0x24, 0x01}},
+		//a float32 is widened to float64 when it shares a vector with a float64
+		{"widened float", func(b *Builder) error {
+			b.StartVector()
+			b.Float(1.5)
+			b.Float(1e300)
+			b.End()
+			return nil
+		}, []byte{
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x3f,
+			0x9c, 0x75, 0x00, 0x88, 0x3c, 0xe4, 0x37, 0x7e,
+			0x0f, 0x0f, 0x12, 0x2b, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder()
+			require.NoError(t, tt.build(b))
+			require.NoError(t, b.Finish())
+			var buff []byte
+			_, err := b.SerializeBuffer(&buff)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, buff)
+		})
+	}
+}
+
+//offsets are sized for the distance to their target, which is only known once the children are serialized
+func TestSerializeWideOffsets(t *testing.T) {
+	b := NewBuilder()
+	require.NoError(t, b.StartVector())
+	require.NoError(t, b.String(string(make([]byte, 300))))
+	require.NoError(t, b.Int(1))
+	b.End()
+	var buff []byte
+	_, err := b.SerializeBuffer(&buff)
+	require.NoError(t, err)
+
+	r := NewRef(buff)
+	str, err := r.Index(0)
+	require.NoError(t, err)
+	s, err := str.AsString()
+	require.NoError(t, err)
+	require.Equal(t, 300, len(s))
+	i, err := r.Index(1)
+	require.NoError(t, err)
+	n, err := i.Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+}
+
+func TestSerializeEmptyRoot(t *testing.T) {
+	var buff []byte
+	_, err := NewBuilder().SerializeBuffer(&buff)
+	require.Error(t, err)
+}
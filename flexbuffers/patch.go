@@ -0,0 +1,463 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//An Operation is a single change of a Patch, with the same meaning as in a JSON Patch (RFC 6902)
+type Operation struct {
+	Op    string //add, remove, replace, move, copy or test
+	Path  string //JSON pointer of the changed value
+	From  string //JSON pointer of the source of move and copy
+	Value Ref    //value of add, replace and test
+}
+
+//A Patch is a list of operations, applied in order. It is encoded as a vector of maps with the keys "op", "path",
+//"from" and "value", like a JSON Patch, see MarshalFlexBuffer
+type Patch []Operation
+
+//Diff returns a Patch that turns old into new. Maps are compared key by key and vectors item by item, values that are
+//not Equal are replaced. Items are added or removed at the end of vectors only, so an insertion in the middle of a
+//vector replaces the items after it. The values of the operations refer to new
+func Diff(old, new Ref) (Patch, error) {
+	var p Patch
+	if err := diff(&p, "", old, new, 0); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func diff(p *Patch, path string, old Ref, new Ref, depth int) error {
+	if err := new.checkDepth(depth); err != nil {
+		return err
+	}
+	switch true {
+	case old.IsMap() && new.IsMap():
+		old_keys, err := old.KeyVector()
+		if err != nil {
+			return err
+		}
+		new_keys, err := new.KeyVector()
+		if err != nil {
+			return err
+		}
+		i, j := int64(0), int64(0)
+		for i < int64(old.item_count) || j < int64(new.item_count) {
+			var ok, nk []byte
+			if i < int64(old.item_count) {
+				if ok, err = old_keys.keyBytesAt(i); err != nil {
+					return err
+				}
+			}
+			if j < int64(new.item_count) {
+				if nk, err = new_keys.keyBytesAt(j); err != nil {
+					return err
+				}
+			}
+			switch true {
+			case j == int64(new.item_count) || ok != nil && bytes.Compare(ok, nk) < 0:
+				*p = append(*p, Operation{Op: "remove", Path: path + "/" + pointerEscaper.Replace(string(ok))})
+				i++
+			case i == int64(old.item_count) || bytes.Compare(ok, nk) > 0:
+				val_ref, err := new.Index(j)
+				if err != nil {
+					return err
+				}
+				*p = append(*p, Operation{Op: "add", Path: path + "/" + pointerEscaper.Replace(string(nk)), Value: val_ref})
+				j++
+			default:
+				old_ref, err := old.Index(i)
+				if err != nil {
+					return err
+				}
+				new_ref, err := new.Index(j)
+				if err != nil {
+					return err
+				}
+				if err := diff(p, path+"/"+pointerEscaper.Replace(string(ok)), old_ref, new_ref, depth+1); err != nil {
+					return err
+				}
+				i++
+				j++
+			}
+		}
+		return nil
+	case old.kind() == kindVector && new.kind() == kindVector:
+		n := min(old.item_count, new.item_count)
+		for i := int64(0); i < int64(n); i++ {
+			old_ref, err := old.Index(i)
+			if err != nil {
+				return err
+			}
+			new_ref, err := new.Index(i)
+			if err != nil {
+				return err
+			}
+			if err := diff(p, path+"/"+strconv.FormatInt(i, 10), old_ref, new_ref, depth+1); err != nil {
+				return err
+			}
+		}
+		for i := int64(n); i < int64(new.item_count); i++ {
+			new_ref, err := new.Index(i)
+			if err != nil {
+				return err
+			}
+			*p = append(*p, Operation{Op: "add", Path: path + "/" + strconv.FormatInt(i, 10), Value: new_ref})
+		}
+		//removed from the end, so that the indices of the remaining items do not change
+		for i := int64(old.item_count) - 1; i >= int64(n); i-- {
+			*p = append(*p, Operation{Op: "remove", Path: path + "/" + strconv.FormatInt(i, 10)})
+		}
+		return nil
+	}
+	if !Equal(old, new) {
+		*p = append(*p, Operation{Op: "replace", Path: path, Value: new})
+	}
+	return nil
+}
+
+//MarshalFlexBuffer encodes the patch as a vector of maps, with a copy of the value of every operation that has one
+func (p Patch) MarshalFlexBuffer(b *Builder) error {
+	if err := b.StartVector(); err != nil {
+		return err
+	}
+	for _, op := range p {
+		if err := b.StartMap(); err != nil {
+			return err
+		}
+		if err := b.StringWithKey("op", op.Op); err != nil {
+			return err
+		}
+		if err := b.StringWithKey("path", op.Path); err != nil {
+			return err
+		}
+		if op.Op == "move" || op.Op == "copy" {
+			if err := b.StringWithKey("from", op.From); err != nil {
+				return err
+			}
+		}
+		if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			if err := b.addRef(b.arena.newKey("value"), op.Value, 0); err != nil {
+				return err
+			}
+		}
+		b.End()
+	}
+	b.End()
+	return nil
+}
+
+//UnmarshalFlexBuffer decodes a patch encoded by MarshalFlexBuffer. The values of the operations refer to r. add,
+//replace and test operations without a value are rejected
+func (p *Patch) UnmarshalFlexBuffer(r Ref) error {
+	s, err := r.Scan()
+	if err != nil {
+		return err
+	}
+	*p = (*p)[:0]
+	for s.Next() {
+		var op Operation
+		for k, field := range map[string]*string{"op": &op.Op, "path": &op.Path, "from": &op.From} {
+			f, err := s.Value().MapIndex(k)
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if *field, err = f.String(); err != nil {
+				return err
+			}
+		}
+		op.Value, err = s.Value().MapIndex("value")
+		switch true {
+		case errors.Is(err, ErrKeyNotFound):
+			if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+				return fmt.Errorf("patch operation %d (%s %s) has no value: %w", len(*p), op.Op, op.Path, err)
+			}
+		case err != nil:
+			return err
+		}
+		*p = append(*p, op)
+	}
+	return s.Err()
+}
+
+//ParseJSONPatch reads a JSON Patch (RFC 6902), see FromJSON for the conversion of its values
+func ParseJSONPatch(r io.Reader) (Patch, error) {
+	buff, err := FromJSON(r)
+	if err != nil {
+		return nil, err
+	}
+	var p Patch
+	if err := Unmarshal(buff, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+//Apply applies the operations of the patch to base, in order, and returns the resulting flexbuffer. base is not
+//modified. Operations follow RFC 6902: add, remove, replace, move, copy and test are supported, "-" refers to the end
+//of a vector and a failed test fails the whole patch. Values that are not changed are copied as they are, changed
+//vectors and maps are rebuilt as untyped vectors and maps
+func Apply(base Ref, p Patch) ([]byte, error) {
+	root := &patchNode{ref: base}
+	for i, op := range p {
+		var err error
+		if root, err = root.apply(op); err != nil {
+			return nil, fmt.Errorf("patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	b := NewBuilder()
+	if err := root.build(b, nil); err != nil {
+		return nil, err
+	}
+	var buff []byte
+	if _, err := b.SerializeBuffer(&buff); err != nil {
+		return nil, err
+	}
+	return buff, nil
+}
+
+//a value being patched. Vectors and maps are expanded when the patch changes something inside them
+type patchNode struct {
+	ref      Ref //the value, if it has not been expanded
+	expanded bool
+	isMap    bool
+	keys     []string     //sorted keys of an expanded map
+	items    []*patchNode //items of an expanded vector, values of an expanded map
+}
+
+func (n *patchNode) expand() error {
+	if n.expanded {
+		return nil
+	}
+	r := n.ref
+	switch true {
+	case r.IsMap():
+		kv, err := r.KeyVector()
+		if err != nil {
+			return err
+		}
+		n.keys = make([]string, r.item_count)
+		for i := range n.keys {
+			if n.keys[i], err = kv.keyAt(int64(i)); err != nil {
+				return err
+			}
+		}
+		n.isMap = true
+	case r.kind() == kindVector:
+	default:
+		return r.mismatch("object of type %s has no children", r.context.ItemVarType().toString())
+	}
+	n.items = make([]*patchNode, r.item_count)
+	for i := range n.items {
+		item_ref, err := r.Index(int64(i))
+		if err != nil {
+			return err
+		}
+		n.items[i] = &patchNode{ref: item_ref}
+	}
+	n.expanded = true
+	n.ref = Ref{}
+	return nil
+}
+
+func (n *patchNode) clone() *patchNode {
+	c := *n
+	if n.expanded {
+		c.keys = append([]string{}, n.keys...)
+		c.items = make([]*patchNode, len(n.items))
+		for i, item := range n.items {
+			c.items[i] = item.clone()
+		}
+	}
+	return &c
+}
+
+//returns the position of a child, which must exist unless adding is true. Adding to a vector may return the index
+//right after its last item
+func (n *patchNode) childIndex(segment pathSegment, adding bool) (int, error) {
+	if err := n.expand(); err != nil {
+		return 0, err
+	}
+	if n.isMap {
+		i := sort.SearchStrings(n.keys, string(segment.key))
+		if !adding && (i == len(n.keys) || n.keys[i] != string(segment.key)) {
+			return 0, fmt.Errorf("%w: %q", ErrKeyNotFound, segment.key)
+		}
+		return i, nil
+	}
+	index := int(segment.index)
+	if adding && string(segment.key) == "-" {
+		index = len(n.items)
+	}
+	limit := len(n.items)
+	if adding {
+		limit++
+	}
+	if segment.index < 0 && index != len(n.items) || index >= limit {
+		return 0, fmt.Errorf("%w: %q in a vector of %d item(s)", ErrOutOfBounds, segment.key, len(n.items))
+	}
+	return index, nil
+}
+
+//returns the node a compiled path leads to
+func (n *patchNode) get(segments []pathSegment) (*patchNode, error) {
+	for _, segment := range segments {
+		i, err := n.childIndex(segment, false)
+		if err != nil {
+			return nil, err
+		}
+		n = n.items[i]
+	}
+	return n, nil
+}
+
+//applies the operation and returns the new root
+func (root *patchNode) apply(op Operation) (*patchNode, error) {
+	path, err := CompilePath(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value *patchNode
+	switch op.Op {
+	case "add", "replace", "test":
+		value = &patchNode{ref: op.Value}
+	case "move", "copy":
+		from, err := CompilePath(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("unable to move %s into itself", op.From)
+		}
+		source, err := root.get(from.segments)
+		if err != nil {
+			return nil, err
+		}
+		value = source.clone()
+		if op.Op == "move" {
+			if op.From == op.Path {
+				return root, nil
+			}
+			if root, err = root.remove(from.segments); err != nil {
+				return nil, err
+			}
+		}
+		op.Op = "add"
+	case "remove":
+		return root.remove(path.segments)
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+	segments := path.segments
+	if op.Op == "test" {
+		target, err := root.get(segments)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := target.toRef()
+		if err != nil {
+			return nil, err
+		}
+		if !Equal(actual, op.Value) {
+			return nil, fmt.Errorf("test failed: the values differ")
+		}
+		return root, nil
+	}
+	if len(segments) == 0 {
+		return value, nil
+	}
+	parent, err := root.get(segments[:len(segments)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := segments[len(segments)-1]
+	i, err := parent.childIndex(last, op.Op == "add")
+	if err != nil {
+		return nil, err
+	}
+	switch true {
+	case parent.isMap && i < len(parent.keys) && parent.keys[i] == string(last.key), !parent.isMap && op.Op == "replace":
+		parent.items[i] = value
+	case parent.isMap:
+		parent.keys = append(parent.keys, "")
+		copy(parent.keys[i+1:], parent.keys[i:])
+		parent.keys[i] = string(last.key)
+		fallthrough
+	default:
+		parent.items = append(parent.items, nil)
+		copy(parent.items[i+1:], parent.items[i:])
+		parent.items[i] = value
+	}
+	return root, nil
+}
+
+func (root *patchNode) remove(segments []pathSegment) (*patchNode, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("unable to remove the root")
+	}
+	parent, err := root.get(segments[:len(segments)-1])
+	if err != nil {
+		return nil, err
+	}
+	i, err := parent.childIndex(segments[len(segments)-1], false)
+	if err != nil {
+		return nil, err
+	}
+	if parent.isMap {
+		parent.keys = append(parent.keys[:i], parent.keys[i+1:]...)
+	}
+	parent.items = append(parent.items[:i], parent.items[i+1:]...)
+	return root, nil
+}
+
+//adds the value to the builder, under the key k if it is part of a map
+func (n *patchNode) build(b *Builder, k *key) error {
+	if !n.expanded {
+		return b.addRef(k, n.ref, 0)
+	}
+	var o iStructure = b.arena.newVector()
+	if n.isMap {
+		o = b.arena.newFlexMap()
+	}
+	if err := b.startWithOptionalKey(k, o); err != nil {
+		return err
+	}
+	for i, item := range n.items {
+		var item_key *key
+		if n.isMap {
+			item_key = b.arena.newKey(n.keys[i])
+		}
+		if err := item.build(b, item_key); err != nil {
+			return err
+		}
+	}
+	b.End()
+	return nil
+}
+
+//returns the value, serialized into a new buffer if it has been expanded
+func (n *patchNode) toRef() (Ref, error) {
+	if !n.expanded {
+		return n.ref, nil
+	}
+	b := NewBuilder()
+	if err := n.build(b, nil); err != nil {
+		return Ref{}, err
+	}
+	var buff []byte
+	if _, err := b.SerializeBuffer(&buff); err != nil {
+		return Ref{}, err
+	}
+	return Root(buff)
+}
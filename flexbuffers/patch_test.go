@@ -0,0 +1,138 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustFromJSON(t *testing.T, s string) Ref {
+	buff, err := FromJSON(strings.NewReader(s))
+	require.NoError(t, err, s)
+	return *NewRef(buff)
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		ops      []string //op and path of every operation
+	}{
+		{"equal", `{ "a": [ 1, 2 ] }`, `{ "a": [ 1, 2 ] }`, nil},
+		{"scalar", `1`, `"x"`, []string{"replace "}},
+		{"keys", `{ "a": 1, "b": 2, "d": 4 }`, `{ "b": 2, "c": 3, "d": 5 }`, []string{"remove /a", "add /c", "replace /d"}},
+		{"escaped keys", `{ "a/b": 1 }`, `{ "m~n": 1 }`, []string{"remove /a~1b", "add /m~0n"}},
+		{"longer vector", `[ 1, 2 ]`, `[ 1, 3, 4, 5 ]`, []string{"replace /1", "add /2", "add /3"}},
+		{"shorter vector", `[ 1, 2, 3, 4 ]`, `[ 0 ]`, []string{"replace /0", "remove /3", "remove /2", "remove /1"}},
+		{"nested", `{ "a": { "b": [ { "c": 1 } ] } }`, `{ "a": { "b": [ { "c": 2 } ] } }`, []string{"replace /a/b/0/c"}},
+		{"kind change", `{ "a": [ 1 ] }`, `{ "a": { "0": 1 } }`, []string{"replace /a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := mustFromJSON(t, tt.old), mustFromJSON(t, tt.new)
+			p, err := Diff(old, new)
+			require.NoError(t, err)
+			var ops []string
+			for _, op := range p {
+				ops = append(ops, op.Op+" "+op.Path)
+			}
+			require.Equal(t, tt.ops, ops)
+
+			buff, err := Apply(old, p)
+			require.NoError(t, err)
+			require.True(t, Equal(new, *NewRef(buff)), toJSON(t, buff, JSONOptions{}))
+
+			//patches are flexbuffers themselves
+			encoded := mustMarshal(t, p)
+			var decoded Patch
+			require.NoError(t, Unmarshal(encoded, &decoded))
+			require.Len(t, decoded, len(p))
+			buff, err = Apply(old, decoded)
+			require.NoError(t, err)
+			require.True(t, Equal(new, *NewRef(buff)))
+		})
+	}
+
+	//typed vectors and layouts do not show up as changes
+	p, err := Diff(*NewRef(mustMarshal(t, []int8{1, 2})), mustFromJSON(t, `[ 1, 2 ]`))
+	require.NoError(t, err)
+	require.Empty(t, p)
+
+	//corrupt values are reported, never taken for unchanged ones
+	corrupt := mustMarshal(t, map[string]string{"a": "x"})
+	corrupt[bytes.IndexByte(corrupt, 'x')-1] = 0xff //size prefix of the string
+	_, err = Diff(*NewRef(corrupt), *NewRef(corrupt))
+	require.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	//examples of RFC 6902, appendix A
+	tests := []struct {
+		doc, patch, expected string
+	}{
+		{`{ "foo": "bar" }`, `[ { "op": "add", "path": "/baz", "value": "qux" } ]`, `{ "baz": "qux", "foo": "bar" }`},
+		{`{ "foo": [ "bar", "baz" ] }`, `[ { "op": "add", "path": "/foo/1", "value": "qux" } ]`, `{ "foo": [ "bar", "qux", "baz" ] }`},
+		{`{ "baz": "qux", "foo": "bar" }`, `[ { "op": "remove", "path": "/baz" } ]`, `{ "foo": "bar" }`},
+		{`{ "foo": [ "bar", "qux", "baz" ] }`, `[ { "op": "remove", "path": "/foo/1" } ]`, `{ "foo": [ "bar", "baz" ] }`},
+		{`{ "baz": "qux", "foo": "bar" }`, `[ { "op": "replace", "path": "/baz", "value": "boo" } ]`, `{ "baz": "boo", "foo": "bar" }`},
+		{`{ "foo": { "bar": "baz", "waldo": "fred" }, "qux": { "corge": "grault" } }`,
+			`[ { "op": "move", "from": "/foo/waldo", "path": "/qux/thud" } ]`,
+			`{ "foo": { "bar": "baz" }, "qux": { "corge": "grault", "thud": "fred" } }`},
+		{`{ "foo": [ "all", "grass", "cows", "eat" ] }`, `[ { "op": "move", "from": "/foo/1", "path": "/foo/3" } ]`,
+			`{ "foo": [ "all", "cows", "eat", "grass" ] }`},
+		{`{ "baz": "qux", "foo": [ "a", 2, "c" ] }`,
+			`[ { "op": "test", "path": "/baz", "value": "qux" }, { "op": "test", "path": "/foo/1", "value": 2 } ]`,
+			`{ "baz": "qux", "foo": [ "a", 2, "c" ] }`},
+		{`{ "foo": "bar" }`, `[ { "op": "add", "path": "/child", "value": { "grandchild": { } } } ]`,
+			`{ "child": { "grandchild": {  } }, "foo": "bar" }`},
+		{`{ "foo": [ "bar" ] }`, `[ { "op": "add", "path": "/foo/-", "value": [ "abc", "def" ] } ]`,
+			`{ "foo": [ "bar", [ "abc", "def" ] ] }`},
+		{`{ "foo": 1 }`, `[ { "op": "copy", "from": "/foo", "path": "/bar" }, { "op": "replace", "path": "/foo", "value": 2 } ]`,
+			`{ "bar": 1, "foo": 2 }`},
+		{`{ "foo": 1 }`, `[ { "op": "replace", "path": "", "value": [ 1 ] } ]`, `[ 1 ]`},
+		//a copy is not changed by later operations on its source
+		{`{ "a": [ 1 ] }`, `[ { "op": "copy", "from": "/a", "path": "/b" }, { "op": "add", "path": "/a/-", "value": 2 },
+			{ "op": "test", "path": "/b", "value": [ 1 ] } ]`, `{ "a": [ 1, 2 ], "b": [ 1 ] }`},
+	}
+	for _, tt := range tests {
+		p, err := ParseJSONPatch(strings.NewReader(tt.patch))
+		require.NoError(t, err, tt.patch)
+		buff, err := Apply(mustFromJSON(t, tt.doc), p)
+		require.NoError(t, err, tt.patch)
+		require.Equal(t, tt.expected, toJSON(t, buff, JSONOptions{}), tt.patch)
+	}
+
+	for _, tt := range []struct {
+		doc, patch string
+		sentinel   error
+	}{
+		{`{ "baz": "qux" }`, `[ { "op": "test", "path": "/baz", "value": "bar" } ]`, nil},
+		{`{ "foo": "bar" }`, `[ { "op": "add", "path": "/baz/bat", "value": "qux" } ]`, ErrKeyNotFound},
+		{`{ "foo": "bar" }`, `[ { "op": "remove", "path": "/baz" } ]`, ErrKeyNotFound},
+		{`{ "foo": "bar" }`, `[ { "op": "replace", "path": "/baz", "value": 1 } ]`, ErrKeyNotFound},
+		{`[ 1 ]`, `[ { "op": "add", "path": "/2", "value": 1 } ]`, ErrOutOfBounds},
+		{`[ 1 ]`, `[ { "op": "remove", "path": "/-" } ]`, ErrOutOfBounds},
+		{`{ "a": 1 }`, `[ { "op": "add", "path": "/a/b", "value": 1 } ]`, ErrTypeMismatch},
+		{`{ "a": { "b": 1 } }`, `[ { "op": "move", "from": "/a", "path": "/a/c" } ]`, nil},
+		{`{ "a": 1 }`, `[ { "op": "remove", "path": "" } ]`, nil},
+		{`{ "a": 1 }`, `[ { "op": "frobnicate", "path": "/a" } ]`, nil},
+	} {
+		p, err := ParseJSONPatch(strings.NewReader(tt.patch))
+		require.NoError(t, err, tt.patch)
+		_, err = Apply(mustFromJSON(t, tt.doc), p)
+		require.Error(t, err, tt.patch)
+		if tt.sentinel != nil {
+			require.ErrorIs(t, err, tt.sentinel, tt.patch)
+		}
+	}
+
+	//add, replace and test need a value, null is one
+	for _, op := range []string{"add", "replace", "test"} {
+		_, err := ParseJSONPatch(strings.NewReader(`[ { "op": "` + op + `", "path": "/a" } ]`))
+		require.ErrorIs(t, err, ErrKeyNotFound, op)
+		_, err = ParseJSONPatch(strings.NewReader(`[ { "op": "` + op + `", "path": "/a", "value": null } ]`))
+		require.NoError(t, err, op)
+	}
+}
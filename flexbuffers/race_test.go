@@ -0,0 +1,6 @@
+//go:build race
+
+package flexbuffers
+
+//the race detector makes sync.Pool drop pooled values at random, so pooled allocations can not be counted
+const raceEnabled = true
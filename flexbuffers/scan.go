@@ -0,0 +1,180 @@
+package flexbuffers
+
+import (
+	"iter"
+	"unsafe"
+)
+
+//All iterates over the indices and items of a vector, or the indices and values of a map in key order. Iteration
+//stops early at the first item that can not be read, without reporting it, so a corrupt vector looks shorter than it
+//is. Use a Scanner and its Err method when the buffer has not been checked with Verify. Other values yield nothing
+func (r Ref) All() iter.Seq2[int, Ref] {
+	return func(yield func(int, Ref) bool) {
+		if !r.IsMap() && (!r.IsVector() || isScalar(r.context.ItemVarType())) {
+			return
+		}
+		for i := int64(0); i < int64(r.item_count); i++ {
+			item_ref, err := r.Index(i)
+			if err != nil || !yield(int(i), item_ref) {
+				return
+			}
+		}
+	}
+}
+
+//Entries iterates over the keys and values of a map, in key order. Iteration stops early at the first entry that can
+//not be read, without reporting it. Use a Scanner and its Err method when the buffer has not been checked with Verify.
+//Other values yield nothing
+func (r Ref) Entries() iter.Seq2[string, Ref] {
+	return func(yield func(string, Ref) bool) {
+		s, err := r.Scan()
+		if err != nil || !s.IsMap() {
+			return
+		}
+		for s.Next() {
+			if !yield(s.Key(), s.Value()) {
+				return
+			}
+		}
+	}
+}
+
+//Keys iterates over the keys of a map, in order. Iteration stops early at the first key that can not be read, without
+//reporting it. Use a Scanner and its Err method when the buffer has not been checked with Verify. Other values yield
+//nothing
+func (r Ref) Keys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if !r.IsMap() {
+			return
+		}
+		kv, err := r.KeyVector()
+		if err != nil {
+			return
+		}
+		for i := int64(0); i < int64(kv.item_count); i++ {
+			k, err := kv.keyAt(i)
+			if err != nil || !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+//A Scanner steps through the items of a vector or the entries of a map:
+//
+//	s, err := r.Scan()
+//	...
+//	for s.Next() {
+//		v := s.Value()
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+type Scanner struct {
+	ref   Ref
+	keys  Ref   //key vector, if ref is a map
+	index int64 //index of the current item, -1 before the first call to Next
+	item  Ref
+	key   string
+	err   error
+}
+
+//Scan returns a Scanner over the items of a vector or the entries of a map
+func (r Ref) Scan() (*Scanner, error) {
+	s := &Scanner{ref: r, index: -1}
+	switch true {
+	case r.IsMap():
+		var err error
+		if s.keys, err = r.KeyVector(); err != nil {
+			return nil, err
+		}
+	case !r.IsVector() || isScalar(r.context.ItemVarType()):
+		return nil, r.mismatch("object of type %s can not be scanned", r.context.ItemVarType().toString())
+	}
+	return s, nil
+}
+
+//IsMap reports whether the Scanner steps through the entries of a map
+func (s *Scanner) IsMap() bool {
+	return s.ref.IsMap()
+}
+
+//Next advances to the next item. It returns false at the end of the items, or if an item can not be read
+func (s *Scanner) Next() bool {
+	if s.err != nil || s.index+1 >= int64(s.ref.item_count) {
+		s.item, s.key = Ref{}, ""
+		if s.err == nil {
+			s.index = int64(s.ref.item_count)
+		}
+		return false
+	}
+	s.index++
+	if s.err = s.read(); s.err != nil {
+		s.item, s.key = Ref{}, ""
+		return false
+	}
+	return true
+}
+
+func (s *Scanner) read() error {
+	var err error
+	if s.item, err = s.ref.Index(s.index); err != nil {
+		return err
+	}
+	if s.IsMap() {
+		s.key, err = s.keys.keyAt(s.index)
+	}
+	return err
+}
+
+//Index returns the index of the current item
+func (s *Scanner) Index() int {
+	return int(s.index)
+}
+
+//Value returns the current item, or a zero Ref if Next has not returned true
+func (s *Scanner) Value() Ref {
+	return s.item
+}
+
+//Key returns the key of the current entry of a map, or an empty string
+func (s *Scanner) Key() string {
+	return s.key
+}
+
+//Err returns the error that stopped the Scanner, if any
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+//Reset moves the Scanner back before the first item and clears its error
+func (s *Scanner) Reset() {
+	s.index = -1
+	s.item, s.key, s.err = Ref{}, "", nil
+}
+
+//SeekKey moves the Scanner of a map so that the next call to Next returns the first entry whose key is not less than
+//k. It reports whether the map contains k
+func (s *Scanner) SeekKey(k string) bool {
+	if !s.IsMap() {
+		s.err = s.ref.mismatch("object of type %s does not support key mapping", s.ref.context.ItemVarType().toString())
+		return false
+	}
+	s.Reset()
+	key := *(*[]byte)(unsafe.Pointer(&k))
+	i, err := s.keys.lowerBoundKey(0, int64(s.keys.item_count), key)
+	if err != nil {
+		s.err = err
+		return false
+	}
+	s.index = i - 1
+	if i == int64(s.keys.item_count) {
+		return false
+	}
+	c, err := s.keys.compareKeyAt(i, key)
+	if err != nil {
+		s.err = err
+		return false
+	}
+	return c == 0
+}
@@ -0,0 +1,105 @@
+package flexbuffers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+//Streams carry a sequence of flexbuffers, each one prefixed with its size as a 4 byte little-endian unsigned integer
+const frameHeaderSize = 4
+
+//Buffers larger than this are rejected by a Decoder unless configured otherwise with SetMaxSize
+const DefaultMaxFrameSize = 64 << 20
+
+//An Encoder writes length-prefixed flexbuffers to an output stream
+type Encoder struct {
+	w      io.Writer
+	buff   []byte
+	header [frameHeaderSize]byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+//Encode marshals v (see Marshal) and writes it to the stream as a single frame
+func (e *Encoder) Encode(v interface{}) error {
+	buff, err := MarshalAppend(e.buff[:0], v)
+	if err != nil {
+		return err
+	}
+	e.buff = buff
+	return e.WriteBuffer(e.buff)
+}
+
+//WriteBuffer writes an already serialized flexbuffer to the stream as a single frame
+func (e *Encoder) WriteBuffer(buff []byte) error {
+	if uint64(len(buff)) > uint64(^uint32(0)) {
+		return fmt.Errorf("unable to encode a flexbuffer of %d bytes: frames are limited to 4GiB", len(buff))
+	}
+	binary.LittleEndian.PutUint32(e.header[:], uint32(len(buff)))
+	if _, err := e.w.Write(e.header[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(buff)
+	return err
+}
+
+//A Decoder reads length-prefixed flexbuffers from an input stream
+type Decoder struct {
+	r       io.Reader
+	maxSize uint32
+	header  [frameHeaderSize]byte
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, maxSize: DefaultMaxFrameSize}
+}
+
+//SetMaxSize limits the size of a single flexbuffer accepted by the decoder
+func (d *Decoder) SetMaxSize(size uint32) {
+	d.maxSize = size
+}
+
+//NextBuffer reads the next frame from the stream. It returns io.EOF when the stream ends cleanly between frames
+//and io.ErrUnexpectedEOF when it ends in the middle of one. Every call returns a newly allocated buffer
+func (d *Decoder) NextBuffer() ([]byte, error) {
+	if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(d.header[:])
+	if size > d.maxSize {
+		return nil, fmt.Errorf("flexbuffer of %d bytes exceeds the maximum frame size of %d bytes", size, d.maxSize)
+	}
+	if size < 3 {
+		return nil, fmt.Errorf("frame of %d byte(s) is too short to hold a flexbuffer", size)
+	}
+	buff := make([]byte, size)
+	if _, err := io.ReadFull(d.r, buff); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buff, nil
+}
+
+//Next reads the next frame from the stream and returns a reference to its root.
+//The reference stays valid after subsequent calls, as every frame is read into its own buffer
+func (d *Decoder) Next() (Ref, error) {
+	buff, err := d.NextBuffer()
+	if err != nil {
+		return Ref{}, err
+	}
+	return Root(buff)
+}
+
+//Decode reads the next frame from the stream and unmarshals it into v (see Unmarshal)
+func (d *Decoder) Decode(v interface{}) error {
+	r, err := d.Next()
+	if err != nil {
+		return err
+	}
+	return unmarshalRef(r, v)
+}
@@ -0,0 +1,284 @@
+package flexbuffers
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//Describes a flexbuffers value that could not be stored in a Go value of the given type
+type UnmarshalTypeError struct {
+	Value VarType      //flexbuffers type of the value
+	Type  reflect.Type //type of the Go value it could not be assigned to
+	Field string       //path to the struct field holding the Go value, if any
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("unable to unmarshal flexbuffers object of type %s into Go struct field %s of type %s", e.Value.toString(), e.Field, e.Type.String())
+	}
+	return fmt.Sprintf("unable to unmarshal flexbuffers object of type %s into Go value of type %s", e.Value.toString(), e.Type.String())
+}
+
+//Unmarshal decodes the flexbuffer in buff and stores the result in the value pointed to by v.
+//Maps decode into structs (matched by field tags or names) and map[string]T, vectors decode into slices and arrays,
+//NULL sets pointers, interfaces, maps and slices to nil and leaves other values unchanged. Numbers are converted like
+//Get converts them, values that do not fit in the Go value are reported with ErrOverflow
+func Unmarshal(buff []byte, v interface{}) error {
+	r, err := Root(buff)
+	if err != nil {
+		return err
+	}
+	return unmarshalRef(r, v)
+}
+
+func unmarshalRef(r Ref, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("unable to unmarshal into non-pointer or nil value of type %T", v)
+	}
+	return unmarshal(r, rv.Elem(), "", 0)
+}
+
+func unmarshal(r Ref, v reflect.Value, path string, depth int) error {
+	if err := r.checkDepth(depth); err != nil {
+		return err
+	}
+	vType := r.context.ItemVarType()
+	mismatch := func() error {
+		return &UnmarshalTypeError{Value: vType, Type: v.Type(), Field: path}
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		if u, ok := v.Addr().Interface().(Unmarshaler); ok {
+			if err := u.UnmarshalFlexBuffer(r); err != nil {
+				return fmt.Errorf("error calling UnmarshalFlexBuffer for type %s%s: %w", v.Type().String(), inField(path), err)
+			}
+			return nil
+		}
+	}
+	if r.IsNull() {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshal(r, v.Elem(), path, depth)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return mismatch()
+		}
+		x, err := unmarshalInterface(r, depth)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(x))
+	case reflect.Bool:
+		if !r.IsBool() {
+			return mismatch()
+		}
+		x, err := r.Bool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(x)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if vType != INT && vType != INDIRECT_INT && vType != UINT && vType != INDIRECT_UINT {
+			return mismatch()
+		}
+		//numbers are converted like Get does, values that do not fit are reported with ErrOverflow
+		return r.setScalar(v)
+	case reflect.Float32, reflect.Float64:
+		if vType != FLOAT && vType != INDIRECT_FLOAT && vType != INT && vType != INDIRECT_INT && vType != UINT && vType != INDIRECT_UINT {
+			return mismatch()
+		}
+		return r.setScalar(v)
+	case reflect.String:
+		if !r.IsString() && !r.IsKey() {
+			return mismatch()
+		}
+		x, err := r.String()
+		if err != nil {
+			return err
+		}
+		v.SetString(x)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (vType == BLOB || vType == STRING) {
+			data, err := r.getBytes(r.index_0, r.item_count)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte{}, data...))
+			return nil
+		}
+		if !r.IsVector() {
+			return mismatch()
+		}
+		n := int(r.item_count)
+		if v.IsNil() || v.Cap() < n {
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		}
+		v.SetLen(n)
+		return unmarshalElems(r, v, n, path, depth)
+	case reflect.Array:
+		if !r.IsVector() {
+			return mismatch()
+		}
+		n := int(r.item_count)
+		if n > v.Len() {
+			n = v.Len()
+		}
+		if err := unmarshalElems(r, v, n, path, depth); err != nil {
+			return err
+		}
+		zero := reflect.Zero(v.Type().Elem())
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).Set(zero)
+		}
+	case reflect.Map:
+		if !r.IsMap() {
+			return mismatch()
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unable to unmarshal into map with non-string key type %s%s", v.Type().Key().String(), inField(path))
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		keys, err := r.KeyVector()
+		if err != nil {
+			return err
+		}
+		elemType := v.Type().Elem()
+		for i := int64(0); i < int64(r.item_count); i++ {
+			k, err := keys.keyAt(i)
+			if err != nil {
+				return err
+			}
+			val_ref, err := r.Index(i)
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(elemType).Elem()
+			if err := unmarshal(val_ref, elem, path+"."+k, depth+1); err != nil {
+				return atSegment(err, keySegment(k))
+			}
+			v.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), elem)
+		}
+	case reflect.Struct:
+		if !r.IsMap() {
+			return mismatch()
+		}
+		fields := typeFields(v.Type())
+		keys, err := r.KeyVector()
+		if err != nil {
+			return err
+		}
+		for i := int64(0); i < int64(r.item_count); i++ {
+			k, err := keys.keyAt(i)
+			if err != nil {
+				return err
+			}
+			f := fieldByName(fields, k)
+			if f == nil {
+				continue //unknown keys are ignored
+			}
+			val_ref, err := r.Index(i)
+			if err != nil {
+				return err
+			}
+			fv, err := fieldByIndex(v, f.index)
+			if err != nil {
+				return err
+			}
+			if err := unmarshal(val_ref, fv, fieldPath(path, v.Type(), f.name), depth+1); err != nil {
+				return atSegment(err, keySegment(k))
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+func unmarshalElems(r Ref, v reflect.Value, n int, path string, depth int) error {
+	for i := 0; i < n; i++ {
+		item_ref, err := r.Index(int64(i))
+		if err != nil {
+			return err
+		}
+		if err := unmarshal(item_ref, v.Index(i), fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+			return atSegment(err, indexSegment(int64(i)))
+		}
+	}
+	return nil
+}
+
+//decodes into the same native types as Ref.Interface, but also supports strings, keys and blobs nested at any depth
+func unmarshalInterface(r Ref, depth int) (interface{}, error) {
+	if err := r.checkDepth(depth); err != nil {
+		return nil, err
+	}
+	switch r.context.ItemVarType() {
+	case STRING, KEY:
+		return r.String()
+	case BLOB:
+		data, err := r.getBytes(r.index_0, r.item_count)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, data...), nil
+	case VECTOR:
+		result := make([]interface{}, r.item_count)
+		for i := range result {
+			item_ref, err := r.Index(int64(i))
+			if err != nil {
+				return nil, err
+			}
+			if result[i], err = unmarshalInterface(item_ref, depth+1); err != nil {
+				return nil, atSegment(err, indexSegment(int64(i)))
+			}
+		}
+		return result, nil
+	case MAP:
+		keys, err := r.KeyVector()
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]interface{}, r.item_count)
+		for i := int64(0); i < int64(r.item_count); i++ {
+			k, err := keys.keyAt(i)
+			if err != nil {
+				return nil, err
+			}
+			val_ref, err := r.Index(i)
+			if err != nil {
+				return nil, err
+			}
+			if result[k], err = unmarshalInterface(val_ref, depth+1); err != nil {
+				return nil, atSegment(err, keySegment(k))
+			}
+		}
+		return result, nil
+	}
+	return r.Interface()
+}
+
+func fieldPath(path string, t reflect.Type, name string) string {
+	if path == "" {
+		return t.Name() + "." + name
+	}
+	return path + "." + name
+}
+
+func inField(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " (struct field " + path + ")"
+}
@@ -0,0 +1,295 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"fmt"
+)
+
+//Nesting of vectors and maps accepted by Verify unless configured otherwise with VerifyOptions.MaxDepth
+const DefaultMaxDepth = 64
+
+//Limits applied by Verify. The zero value applies DefaultMaxDepth and no size limit
+type VerifyOptions struct {
+	MaxDepth int //maximum nesting of vectors, maps and other structures, DefaultMaxDepth if 0
+	MaxSize  int //maximum size of the buffer in bytes, unlimited if 0
+}
+
+//Verify checks that buff holds a well-formed flexbuffer, so that it can be read with NewRef without going out of
+//bounds. Every offset, byte width, type code and size prefix is checked against the buffer, strings and keys must be
+//0-terminated and every map must have a key vector of the same length. Verify does not decode values, but keeps the
+//height of every structure it has verified so that each one is visited once, even if it is referenced by several
+//offsets, while shared structures are still held to VerifyOptions.MaxDepth at every place they are referenced from.
+//Malformed buffers are reported with a *RefError wrapping ErrCorrupt, buffers larger than VerifyOptions.MaxSize with a
+//*RefError wrapping ErrTooLarge
+func Verify(buff []byte, opts VerifyOptions) error {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	n := len(buff)
+	if opts.MaxSize > 0 && n > opts.MaxSize {
+		return &RefError{Err: ErrTooLarge, Offset: uint64(opts.MaxSize),
+			msg: fmt.Sprintf("flexbuffer of %d bytes exceeds the maximum size of %d bytes", n, opts.MaxSize)}
+	}
+	v := verifier{buff: buff, opts: opts}
+	if n < 3 {
+		return v.corrupt(0, "buffer of %d byte(s) is too short to hold a flexbuffer", n)
+	}
+	bWidth := uint64(buff[n-1])
+	if !isValidWidth(bWidth) {
+		return v.corrupt(uint64(n-1), "invalid root byte width %d", bWidth)
+	}
+	if bWidth > uint64(n-2) {
+		return v.corrupt(uint64(n-1), "root byte width %d exceeds the buffer", bWidth)
+	}
+	con := context(buff[n-2])
+	if err := v.checkType(uint64(n-2), con.ItemVarType()); err != nil {
+		return err
+	}
+	index_0 := uint64(n-2) - bWidth
+	if isInline(con.ItemVarType()) {
+		//inline values are read with the width of the root
+		return v.checkInline(index_0, con.ItemVarType(), b(int(bWidth)))
+	}
+	return v.verifyOffset(index_0, bWidth, con)
+}
+
+type verifiedRef struct {
+	index_0 uint64
+	con     context
+}
+
+type verifier struct {
+	buff     []byte
+	opts     VerifyOptions
+	depth    int
+	height   int                 //height of the tallest structure verified below the current one
+	verified map[verifiedRef]int //heights of the structures that have been verified completely
+}
+
+func isValidWidth(w uint64) bool {
+	return w == 1 || w == 2 || w == 4 || w == 8
+}
+
+func isValidType(vType VarType) bool {
+	return vType <= BOOL || vType == VECTOR_BOOL
+}
+
+//reports an ErrCorrupt without a path, as Verify does not keep track of how a structure has been reached
+func (v *verifier) corrupt(offset uint64, format string, args ...interface{}) error {
+	return &RefError{Err: ErrCorrupt, Offset: offset, msg: fmt.Sprintf(format, args...)}
+}
+
+func (v *verifier) checkType(offset uint64, vType VarType) error {
+	if !isValidType(vType) {
+		return v.corrupt(offset, "unknown type code %d", uint8(vType))
+	}
+	return nil
+}
+
+//checks that [offset, offset+size) lies within the buffer
+func (v *verifier) checkBounds(offset uint64, size uint64) error {
+	n := uint64(len(v.buff))
+	if offset > n || size > n-offset {
+		return v.corrupt(offset, "%d byte(s) out of bounds of a buffer of %d bytes", size, n)
+	}
+	return nil
+}
+
+//checks that count items of the given width starting at offset lie within the buffer
+func (v *verifier) checkItems(offset uint64, count uint64, width uint64) error {
+	n := uint64(len(v.buff))
+	if count > n {
+		return v.corrupt(offset, "size %d exceeds the buffer", count)
+	}
+	return v.checkBounds(offset, count*width)
+}
+
+//floats can only be read with 4 or 8 bytes
+func (v *verifier) checkInline(offset uint64, vType VarType, bSize ByteSize) error {
+	if vType == FLOAT && bSize < b32 {
+		return v.corrupt(offset, "float of %d byte(s)", B(bSize))
+	}
+	return v.checkBounds(offset, B(bSize))
+}
+
+//reads the size prefix stored right before index_0
+func (v *verifier) sizePrefix(index_0 uint64, width uint64) (uint64, error) {
+	if index_0 < width {
+		return 0, v.corrupt(index_0, "size prefix out of bounds")
+	}
+	if err := v.checkBounds(index_0-width, width); err != nil {
+		return 0, err
+	}
+	return bytesAsUint(v.buff[index_0-width : index_0]...), nil
+}
+
+//follows the offset of the given width stored at pos and verifies its target
+func (v *verifier) verifyOffset(pos uint64, width uint64, con context) error {
+	if err := v.checkBounds(pos, width); err != nil {
+		return err
+	}
+	off := bytesAsUint(v.buff[pos : pos+width]...)
+	if off > pos {
+		return v.corrupt(pos, "offset %d points before the start of the buffer", off)
+	}
+	return v.verifyRef(pos-off, con)
+}
+
+func (v *verifier) verifyRef(index_0 uint64, con context) error {
+	ref := verifiedRef{index_0, con}
+	if height, ok := v.verified[ref]; ok {
+		//readers walk a shared structure as a tree, so it has to fit below every place it is referenced from
+		if v.depth+height > v.opts.MaxDepth {
+			return v.corrupt(index_0, "structures nested deeper than %d levels", v.opts.MaxDepth)
+		}
+		v.updateHeight(height)
+		return nil
+	}
+	if v.depth >= v.opts.MaxDepth {
+		return v.corrupt(index_0, "structures nested deeper than %d levels", v.opts.MaxDepth)
+	}
+	outer := v.height
+	v.height = 0
+	v.depth++
+	err := v.verifyStructure(index_0, con)
+	v.depth--
+	height := v.height + 1
+	v.height = outer
+	if err != nil {
+		return err
+	}
+	if v.verified == nil {
+		v.verified = map[verifiedRef]int{}
+	}
+	v.verified[ref] = height
+	v.updateHeight(height)
+	return nil
+}
+
+func (v *verifier) updateHeight(height int) {
+	if height > v.height {
+		v.height = height
+	}
+}
+
+func (v *verifier) verifyStructure(index_0 uint64, con context) error {
+	vType := con.ItemVarType()
+	bSize := con.ItemByteSize()
+	width := B(bSize)
+	switch true {
+	case vType == KEY:
+		if index_0 >= uint64(len(v.buff)) || bytes.IndexByte(v.buff[index_0:], 0) < 0 {
+			return v.corrupt(index_0, "key is not 0-terminated")
+		}
+		return nil
+	case vType == STRING, vType == BLOB:
+		size, err := v.sizePrefix(index_0, width)
+		if err != nil {
+			return err
+		}
+		if vType == BLOB {
+			return v.checkItems(index_0, size, 1)
+		}
+		if err := v.checkItems(index_0, size+1, 1); err != nil {
+			return err
+		}
+		if v.buff[index_0+size] != 0 {
+			return v.corrupt(index_0+size, "string is not 0-terminated")
+		}
+		return nil
+	case vType == INDIRECT_INT, vType == INDIRECT_UINT, vType == INDIRECT_FLOAT:
+		return v.checkInline(index_0, vType-5, bSize)
+	case isTuple(vType), isTriple(vType), isQuad(vType):
+		count := fixedItemCount(vType)
+		if isFloatTyped(vType) && bSize < b32 {
+			return v.corrupt(index_0, "floats of %d byte(s)", width)
+		}
+		return v.checkItems(index_0, count, width)
+	case vType == VECTOR_INT, vType == VECTOR_UINT, vType == VECTOR_FLOAT, vType == VECTOR_BOOL:
+		size, err := v.sizePrefix(index_0, width)
+		if err != nil {
+			return err
+		}
+		if vType == VECTOR_FLOAT && bSize < b32 {
+			return v.corrupt(index_0, "floats of %d byte(s)", width)
+		}
+		return v.checkItems(index_0, size, width)
+	case vType == VECTOR_KEY, vType == VECTOR_STRING_DEPRECATED:
+		size, err := v.sizePrefix(index_0, width)
+		if err != nil {
+			return err
+		}
+		if err := v.checkItems(index_0, size, width); err != nil {
+			return err
+		}
+		item := Pack(KEY, b8)
+		if vType == VECTOR_STRING_DEPRECATED {
+			item = Pack(STRING, bSize)
+		}
+		for i := uint64(0); i < size; i++ {
+			if err := v.verifyOffset(index_0+i*width, width, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case vType == MAP:
+		if index_0 < 3*width {
+			return v.corrupt(index_0, "map prefix out of bounds")
+		}
+		size, err := v.sizePrefix(index_0, width)
+		if err != nil {
+			return err
+		}
+		keysWidth := bytesAsUint(v.buff[index_0-2*width : index_0-width]...)
+		if !isValidWidth(keysWidth) {
+			return v.corrupt(index_0-2*width, "invalid key vector byte width %d", keysWidth)
+		}
+		keys := Pack(VECTOR_KEY, b(int(keysWidth)))
+		if err := v.verifyOffset(index_0-3*width, width, keys); err != nil {
+			return err
+		}
+		kv := Ref{buffer: v.buff, index_0: index_0 - 3*width - bytesAsUint(v.buff[index_0-3*width:index_0-2*width]...), context: keys}
+		count, err := kv.itemCount()
+		if err != nil {
+			return err
+		}
+		if count != size {
+			return v.corrupt(index_0, "map of %d value(s) has %d key(s)", size, count)
+		}
+		return v.verifyVector(index_0, size, width)
+	case vType == VECTOR:
+		size, err := v.sizePrefix(index_0, width)
+		if err != nil {
+			return err
+		}
+		return v.verifyVector(index_0, size, width)
+	}
+	return v.corrupt(index_0, "unexpected structure of type %s", vType.toString())
+}
+
+//verifies the items of an untyped vector or map, followed by their packed types
+func (v *verifier) verifyVector(index_0 uint64, size uint64, width uint64) error {
+	if err := v.checkItems(index_0, size, width+1); err != nil {
+		return err
+	}
+	types := index_0 + size*width
+	for i := uint64(0); i < size; i++ {
+		con := context(v.buff[types+i])
+		vType := con.ItemVarType()
+		if err := v.checkType(types+i, vType); err != nil {
+			return err
+		}
+		pos := index_0 + i*width
+		if isInline(vType) {
+			//inline items are read with the width of the parent
+			if err := v.checkInline(pos, vType, b(int(width))); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := v.verifyOffset(pos, width, con); err != nil {
+			return err
+		}
+	}
+	return nil
+}
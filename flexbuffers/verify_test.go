@@ -0,0 +1,114 @@
+package flexbuffers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	b := NewBuilder()
+	require.NoError(t, b.StartMap())
+	require.NoError(t, b.StartBlobWithKey("blob", []byte{1, 2, 3}))
+	b.End()
+	require.NoError(t, b.StartFloatTripleWithKey("triple"))
+	require.NoError(t, b.Float(1))
+	require.NoError(t, b.Float(2))
+	require.NoError(t, b.Float(0.1))
+	b.End()
+	require.NoError(t, b.StartUintScalarWithKey("indirect"))
+	require.NoError(t, b.Uint(300))
+	b.End()
+	require.NoError(t, b.StartVectorWithKey("vec"))
+	require.NoError(t, b.Key("k"))
+	require.NoError(t, b.String(strings.Repeat("s", 300)))
+	require.NoError(t, b.Float(2.5))
+	require.NoError(t, b.Null())
+	b.End()
+	require.NoError(t, b.StartTypedBoolVectorWithKey("bools"))
+	require.NoError(t, b.Bool(true))
+	b.End()
+	b.End()
+	valid, err := b.Bytes()
+	require.NoError(t, err)
+
+	buffers := [][]byte{valid}
+	for _, v := range []interface{}{1, -1.5, "s", nil, true, []int{1, 2}, []interface{}{}, map[string]interface{}{}, Person{Name: "x", Home: &Address{}}} {
+		buffers = append(buffers, mustMarshal(t, v))
+	}
+	for _, buff := range buffers {
+		require.NoError(t, Verify(buff, VerifyOptions{}))
+	}
+
+	require.ErrorIs(t, Verify(nil, VerifyOptions{}), ErrCorrupt)
+	require.ErrorIs(t, Verify([]byte{1, 4}, VerifyOptions{}), ErrCorrupt)
+	err = Verify(valid, VerifyOptions{MaxSize: len(valid) - 1})
+	require.ErrorIs(t, err, ErrTooLarge)
+	require.IsType(t, &RefError{}, err)
+	require.NoError(t, Verify(valid, VerifyOptions{MaxSize: len(valid)}))
+	require.Error(t, Verify(valid, VerifyOptions{MaxDepth: 1}))
+	require.NoError(t, Verify(valid, VerifyOptions{MaxDepth: 3}))
+
+	corrupt := func(buff []byte, i int, x byte) []byte {
+		c := append([]byte{}, buff...)
+		c[i] = x
+		return c
+	}
+	n := len(valid)
+	require.Error(t, Verify(corrupt(valid, n-1, 3), VerifyOptions{}), "invalid root width")
+	require.Error(t, Verify(corrupt(valid, n-1, 8), VerifyOptions{}), "root width exceeds the buffer")
+	require.Error(t, Verify(corrupt(valid, n-2, byte(Pack(30, b8))), VerifyOptions{}), "unknown root type")
+	require.Error(t, Verify(corrupt(valid, n-3, 0xff), VerifyOptions{}), "root offset out of bounds")
+	require.Error(t, Verify([]byte{0, byte(Pack(FLOAT, b8)), 1}, VerifyOptions{}), "1 byte float")
+	require.Error(t, Verify([]byte{'a', 'b', 2, byte(Pack(KEY, b8)), 1}, VerifyOptions{}), "key without terminator")
+	require.Error(t, Verify([]byte{2, 'a', 'b', 'c', 3, byte(Pack(STRING, b8)), 1}, VerifyOptions{}), "string without terminator")
+	require.Error(t, Verify([]byte{9, 0, 1, byte(Pack(VECTOR_INT, b8)), 1}, VerifyOptions{}), "size exceeds the buffer")
+
+	//a vector containing itself is rejected by the depth limit
+	cycle := []byte{1, 0, byte(Pack(VECTOR, b8)), 2, byte(Pack(VECTOR, b8)), 1}
+	require.Error(t, Verify(cycle, VerifyOptions{}))
+
+	//items shared by many offsets are verified once: every vector holds 2 offsets to the previous one
+	shared := []byte{1, 0}
+	prev, item := 1, Pack(VECTOR_INT, b8)
+	for i := 0; i < 40; i++ {
+		index_0 := len(shared) + 1
+		shared = append(shared, 2, byte(index_0-prev), byte(index_0+1-prev), byte(item), byte(item))
+		prev, item = index_0, Pack(VECTOR, b8)
+	}
+	shared = append(shared, byte(len(shared)-prev), byte(item), 1)
+	require.NoError(t, Verify(shared, VerifyOptions{}))
+
+	//every buffer accepted by Verify can be decoded without panicking
+	for _, buff := range buffers {
+		for i := range buff {
+			for _, x := range []byte{0, 1, 2, 3, 4, 0x24, 0x7f, 0x80, 0xff, buff[i] + 1, buff[i] - 1} {
+				c := corrupt(buff, i, x)
+				if Verify(c, VerifyOptions{}) != nil {
+					continue
+				}
+				require.NotPanics(t, func() {
+					var out interface{}
+					_ = Unmarshal(c, &out)
+					_, _ = NewRef(c).Interface()
+				}, "buffer %v", c)
+			}
+		}
+	}
+}
+
+//a structure shared through dedup offsets is read as a tree, so it counts against MaxDepth at every place it is used
+func TestVerifySharedDepth(t *testing.T) {
+	d := []interface{}{[]interface{}{[]interface{}{1}}}
+	v := []interface{}{d, []interface{}{[]interface{}{d}}}
+	for _, share := range []bool{false, true} {
+		b := NewBuilderWithOptions(BuilderOptions{ShareStructures: share})
+		require.NoError(t, b.AutoBuild(v))
+		buff, err := b.Bytes()
+		require.NoError(t, err)
+		err = Verify(buff, VerifyOptions{MaxDepth: 5})
+		require.ErrorIs(t, err, ErrCorrupt, "ShareStructures: %v", share)
+		require.NoError(t, Verify(buff, VerifyOptions{MaxDepth: 6}), "ShareStructures: %v", share)
+	}
+}
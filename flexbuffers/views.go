@@ -0,0 +1,349 @@
+package flexbuffers
+
+import (
+	"bytes"
+	"math"
+	"unsafe"
+)
+
+//A Vector is a view of the items of an untyped, typed or fixed typed vector, or of a range of them. It shares the
+//buffer of the Ref it has been created from
+type Vector struct {
+	ref   Ref
+	elem  context //context of every item if ref is typed
+	start uint64  //index of the first item of the view in ref
+	count uint64
+}
+
+//A TypedVector is a view of a typed or fixed typed vector, whose items all have the same type
+type TypedVector struct {
+	Vector
+}
+
+//A Map is a view of the keys and values of a map, or of a range of them. Keys are sorted
+type Map struct {
+	keys   Vector
+	values Vector
+}
+
+//AsVector returns a view of an untyped, typed or fixed typed vector
+func (r Ref) AsVector() (Vector, error) {
+	vType := r.context.ItemVarType()
+	if !r.IsVector() || isScalar(vType) {
+		return Vector{}, r.mismatch("object of type %s can not be viewed as a vector", vType.toString())
+	}
+	if vType == VECTOR {
+		return Vector{ref: r, count: r.item_count}, nil
+	}
+	elem, err := r.typedItemContext()
+	if err != nil {
+		return Vector{}, err
+	}
+	return Vector{ref: r, elem: elem, count: r.item_count}, nil
+}
+
+//AsTypedVector returns a view of a typed or fixed typed vector
+func (r Ref) AsTypedVector() (TypedVector, error) {
+	vType := r.context.ItemVarType()
+	if !r.IsTypedVector() || isScalar(vType) {
+		return TypedVector{}, r.mismatch("object of type %s can not be viewed as a typed vector", vType.toString())
+	}
+	v, err := r.AsVector()
+	return TypedVector{v}, err
+}
+
+//AsMap returns a view of a map
+func (r Ref) AsMap() (Map, error) {
+	if !r.IsMap() {
+		return Map{}, r.mismatch("object of type %s can not be viewed as a map", r.context.ItemVarType().toString())
+	}
+	kv, err := r.KeyVector()
+	if err != nil {
+		return Map{}, err
+	}
+	return Map{
+		keys:   Vector{ref: kv, elem: Pack(KEY, b8), count: kv.item_count},
+		values: Vector{ref: r, count: r.item_count},
+	}, nil
+}
+
+//Len returns the number of items in the view
+func (v Vector) Len() int {
+	return int(v.count)
+}
+
+func (v Vector) outOfBounds(i int) error {
+	return v.ref.newError(ErrOutOfBounds, v.ref.index_0, indexSegment(int64(v.start)+int64(i)), "index %d out of %d", i, v.count)
+}
+
+//At returns the item at index i of the view
+func (v Vector) At(i int) (Ref, error) {
+	if i < 0 || uint64(i) >= v.count {
+		return Ref{}, v.outOfBounds(i)
+	}
+	return v.ref.Index(int64(v.start) + int64(i))
+}
+
+//Slice returns a view of the items [i, j) of the view
+func (v Vector) Slice(i, j int) (Vector, error) {
+	if i < 0 || j < i || uint64(j) > v.count {
+		return Vector{}, v.ref.newError(ErrOutOfBounds, v.ref.index_0, "", "slice [%d:%d] out of %d", i, j, v.count)
+	}
+	v.start += uint64(i)
+	v.count = uint64(j - i)
+	return v, nil
+}
+
+//returns the position and context of the item at index i of the view, after following its offset if it is not
+//stored inline. The size of the item is not read
+func (v Vector) locate(i int) (uint64, context, error) {
+	if i < 0 || uint64(i) >= v.count {
+		return 0, 0, v.outOfBounds(i)
+	}
+	item_index := int64(v.start) + int64(i)
+	con := v.elem
+	if !v.ref.IsTyped() {
+		var err error
+		if con, err = v.ref.getItemContext(item_index); err != nil {
+			return 0, 0, err
+		}
+	}
+	abs_offset := v.ref.absItemOffset(item_index)
+	if !isInline(con.ItemVarType()) {
+		off, err := v.ref.readUint(abs_offset, B(v.ref.context.ItemByteSize()))
+		if err != nil {
+			return 0, 0, err
+		}
+		if off > abs_offset {
+			return 0, 0, v.ref.corrupt(abs_offset, "offset %d points before the start of the buffer", off)
+		}
+		abs_offset -= off
+	}
+	return abs_offset, con, nil
+}
+
+func (v Vector) mismatchAt(i int, abs_offset uint64, con context, target string) error {
+	return v.ref.newError(ErrTypeMismatch, abs_offset, indexSegment(int64(v.start)+int64(i)),
+		"item of type %s can not be converted to %s", con.ItemVarType().toString(), target)
+}
+
+//IntAt reads the INT or INDIRECT_INT at index i of the view, without creating a Ref for it
+func (v Vector) IntAt(i int) (int64, error) {
+	abs_offset, con, err := v.locate(i)
+	if err != nil {
+		return 0, err
+	}
+	if vType := con.ItemVarType(); vType != INT && vType != INDIRECT_INT {
+		return 0, v.mismatchAt(i, abs_offset, con, "int")
+	}
+	bytes, err := v.ref.getBytes(abs_offset, B(con.ItemByteSize()))
+	if err != nil {
+		return 0, err
+	}
+	return bytesAsInt(bytes...), nil
+}
+
+//UintAt reads the UINT or INDIRECT_UINT at index i of the view, without creating a Ref for it
+func (v Vector) UintAt(i int) (uint64, error) {
+	abs_offset, con, err := v.locate(i)
+	if err != nil {
+		return 0, err
+	}
+	if vType := con.ItemVarType(); vType != UINT && vType != INDIRECT_UINT {
+		return 0, v.mismatchAt(i, abs_offset, con, "uint")
+	}
+	return v.ref.readUint(abs_offset, B(con.ItemByteSize()))
+}
+
+//FloatAt reads the FLOAT or INDIRECT_FLOAT at index i of the view, without creating a Ref for it
+func (v Vector) FloatAt(i int) (float64, error) {
+	abs_offset, con, err := v.locate(i)
+	if err != nil {
+		return 0, err
+	}
+	if vType := con.ItemVarType(); vType != FLOAT && vType != INDIRECT_FLOAT {
+		return 0, v.mismatchAt(i, abs_offset, con, "float")
+	}
+	switch con.ItemByteSize() {
+	case b32:
+		u, err := v.ref.readUint(abs_offset, B(b32))
+		return float64(math.Float32frombits(uint32(u))), err
+	case b64:
+		u, err := v.ref.readUint(abs_offset, B(b64))
+		return math.Float64frombits(u), err
+	}
+	return 0, v.ref.newError(ErrCorrupt, abs_offset, indexSegment(int64(v.start)+int64(i)),
+		"float of %d byte(s)", B(con.ItemByteSize()))
+}
+
+//BoolAt reads the BOOL at index i of the view, without creating a Ref for it
+func (v Vector) BoolAt(i int) (bool, error) {
+	abs_offset, con, err := v.locate(i)
+	if err != nil {
+		return false, err
+	}
+	if con.ItemVarType() != BOOL {
+		return false, v.mismatchAt(i, abs_offset, con, "bool")
+	}
+	bytes, err := v.ref.getBytes(abs_offset, 1)
+	if err != nil {
+		return false, err
+	}
+	return bytesAsBool(bytes...), nil
+}
+
+//StringAt returns the contents of the STRING or KEY at index i of the view, without creating a Ref for it
+func (v Vector) StringAt(i int) (string, error) {
+	abs_offset, con, err := v.locate(i)
+	if err != nil {
+		return "", err
+	}
+	switch con.ItemVarType() {
+	case KEY:
+		if abs_offset < uint64(len(v.ref.buffer)) {
+			if n := bytes.IndexByte(v.ref.buffer[abs_offset:], 0); n >= 0 {
+				return string(v.ref.buffer[abs_offset : abs_offset+uint64(n)]), nil
+			}
+		}
+		return "", v.ref.corrupt(abs_offset, "key is not 0-terminated")
+	case STRING:
+		bWidth := B(con.ItemByteSize())
+		if abs_offset < bWidth {
+			return "", v.ref.corrupt(abs_offset, "size prefix out of bounds")
+		}
+		size, err := v.ref.readUint(abs_offset-bWidth, bWidth)
+		if err != nil {
+			return "", err
+		}
+		bytes, err := v.ref.getBytes(abs_offset, size)
+		return string(bytes), err
+	}
+	return "", v.mismatchAt(i, abs_offset, con, "string")
+}
+
+//ElemType returns the type of the items, INT, UINT, FLOAT, BOOL, KEY or STRING
+func (tv TypedVector) ElemType() VarType {
+	return tv.elem.ItemVarType()
+}
+
+//Slice returns a view of the items [i, j) of the view
+func (tv TypedVector) Slice(i, j int) (TypedVector, error) {
+	v, err := tv.Vector.Slice(i, j)
+	return TypedVector{v}, err
+}
+
+//Len returns the number of entries in the view
+func (m Map) Len() int {
+	return m.values.Len()
+}
+
+//At returns the value at index i of the view, in key order
+func (m Map) At(i int) (Ref, error) {
+	return m.values.At(i)
+}
+
+//KeyAt returns the key at index i of the view
+func (m Map) KeyAt(i int) (string, error) {
+	return m.keys.StringAt(i)
+}
+
+//Index returns the value of the given key, see IndexBytes
+func (m Map) Index(key string) (Ref, error) {
+	return m.IndexBytes(*(*[]byte)(unsafe.Pointer(&key)))
+}
+
+//IndexBytes returns the value of the given key. Keys are found with a binary search on the key vector, without
+//allocating
+func (m Map) IndexBytes(key []byte) (Ref, error) {
+	i, err := m.find(key)
+	if err != nil {
+		return Ref{}, err
+	}
+	if i < 0 {
+		return Ref{}, m.values.ref.keyNotFound(string(key))
+	}
+	return m.values.ref.Index(i)
+}
+
+//returns the index of key in the map the view has been created from, or -1 if it is not part of the view
+func (m Map) find(key []byte) (int64, error) {
+	return m.keys.ref.searchKeys(int64(m.keys.start), int64(m.keys.start+m.keys.count), key)
+}
+
+//Finder returns a Finder for the given keys, with their indices in m precomputed
+func (m Map) Finder(keys ...string) (*Finder, error) {
+	f := &Finder{keys: make([][]byte, len(keys)), indices: make([]int64, len(keys)), kv: m.keys}
+	for i, k := range keys {
+		f.keys[i] = []byte(k)
+		var err error
+		if f.indices[i], err = m.find(f.keys[i]); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+//A Finder looks up the same set of keys in many maps. Maps that share their key vector with the map the Finder has
+//been created from, see BuilderOptions.ShareKeyVectors, are read without searching, other maps are searched as with
+//Map.Index. A Finder can be used concurrently
+type Finder struct {
+	keys    [][]byte
+	indices []int64 //index of every key in kv, -1 if it is missing
+	kv      Vector
+}
+
+//Len returns the number of keys of the Finder
+func (f *Finder) Len() int {
+	return len(f.keys)
+}
+
+//Index returns the value of the key at index i of the Finder in m
+func (f *Finder) Index(m Map, i int) (Ref, error) {
+	if i < 0 || i >= len(f.keys) {
+		return Ref{}, m.values.ref.newError(ErrOutOfBounds, m.values.ref.index_0, "", "key index %d out of %d", i, len(f.keys))
+	}
+	item_index := f.indices[i]
+	if !m.sharesKeys(f.kv) {
+		var err error
+		if item_index, err = m.find(f.keys[i]); err != nil {
+			return Ref{}, err
+		}
+	}
+	if item_index < 0 {
+		return Ref{}, m.values.ref.keyNotFound(string(f.keys[i]))
+	}
+	return m.values.ref.Index(item_index)
+}
+
+//reports whether the view has the same key vector and range of keys as kv
+func (m Map) sharesKeys(kv Vector) bool {
+	return m.keys.start == kv.start && m.keys.count == kv.count && m.keys.ref.index_0 == kv.ref.index_0 &&
+		m.keys.ref.context == kv.ref.context && sameBuffer(m.keys.ref.buffer, kv.ref.buffer)
+}
+
+func sameBuffer(a []byte, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+//Keys returns a view of the sorted keys
+func (m Map) Keys() TypedVector {
+	return TypedVector{m.keys}
+}
+
+//Values returns a view of the values, in key order
+func (m Map) Values() Vector {
+	return m.values
+}
+
+//Slice returns a view of the entries [i, j) of the view
+func (m Map) Slice(i, j int) (Map, error) {
+	keys, err := m.keys.Slice(i, j)
+	if err != nil {
+		return Map{}, err
+	}
+	values, err := m.values.Slice(i, j)
+	if err != nil {
+		return Map{}, err
+	}
+	return Map{keys, values}, nil
+}